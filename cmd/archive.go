@@ -0,0 +1,523 @@
+package cmd
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"backup_ollama/internal/utils"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// archiveFormats lists the --format values that write the backup as a single archive file.
+// The archive is named after the format, e.g. '{model}--{version}--backup-{unix time}.tar.zst'.
+var archiveFormats = []string{backupFormatZip, backupFormatTar, backupFormatTarGz, backupFormatTarZst}
+
+// archiveFormatOf returns the archive format of a backup file name from its extension
+func archiveFormatOf(name string) (string, bool) {
+	// Check the longest extensions first so '.tar.gz' isn't taken for something else
+	for _, format := range []string{backupFormatTarGz, backupFormatTarZst, backupFormatTar, backupFormatZip} {
+		if strings.HasSuffix(name, "."+format) {
+			return format, true
+		}
+	}
+	return "", false
+}
+
+// backupWriter receives the files of a backup as backupModel produces them.
+// Names are relative to the backup root and use '/' as separator, e.g. "library/manifests/...";
+// blobs are identified by their file name and stored below "blobs/".
+//
+// backupModel writes backup.json and the manifest before the blobs, so archives can be
+// consumed sequentially; writers for which the manifest marks a complete backup defer it until Close.
+type backupWriter interface {
+	// HasBlob reports whether an identical blob is already stored and doesn't need to be written again
+	HasBlob(blob utils.BlobRef) bool
+	// WriteBlob stores the blob read from open, verifying it against its expected digest and size
+	WriteBlob(fileName string, blob utils.BlobRef, open blobOpener) error
+	// WriteFile stores a small file such as a manifest or backup.json
+	WriteFile(name string, data []byte) error
+	// Close completes the backup
+	Close() error
+	// Abort discards everything written so far
+	Abort()
+}
+
+// blobOpener opens the source of a blob copy positioned at offset, the number of bytes of the blob an
+// earlier run already copied. Writers that can't continue a copy always open at offset 0.
+type blobOpener func(offset int64) (io.Reader, error)
+
+// dirBackupWriter writes an expanded backup directory, optionally with a shared repository blobs directory
+type dirBackupWriter struct {
+	backupPath string
+	blobsDir   string
+	shared     bool              // The blobs directory is shared with other snapshots and must not be removed on abort
+	files      map[string][]byte // Small files, written on Close so the manifest only appears in a complete backup
+	journal    *runJournal       // Journal of a resumable backup, nil otherwise
+}
+
+// newDirBackupWriter creates the backup directory and the blobs directory.
+// A backup directory that already exists is refused rather than merged into, since aborting would remove it.
+// With a journal the backup is resumable: it is recorded in the backup directory, blobs verified by an
+// earlier attempt are kept, partial copies are continued, and an aborted backup is kept for the next attempt.
+func newDirBackupWriter(backupPath, blobsDir string, shared bool, journal *runJournal) (*dirBackupWriter, error) {
+	if journal != nil && journal.Attempts > 0 {
+		// The journal was loaded from the incomplete backup an earlier attempt left in backupPath
+		if err := os.MkdirAll(backupPath, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create backup directory: %w", err)
+		}
+	} else {
+		if err := os.MkdirAll(filepath.Dir(backupPath), 0755); err != nil {
+			return nil, fmt.Errorf("failed to create backup directory: %w", err)
+		}
+		if err := os.Mkdir(backupPath, 0755); os.IsExist(err) {
+			return nil, fmt.Errorf("backup %s already exists", backupPath)
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to create backup directory: %w", err)
+		}
+	}
+	if err := os.MkdirAll(blobsDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create blobs directory: %w", err)
+	}
+	if journal != nil {
+		if err := journal.start(backupPath); err != nil {
+			return nil, err
+		}
+	}
+	return &dirBackupWriter{
+		backupPath: backupPath,
+		blobsDir:   blobsDir,
+		shared:     shared,
+		files:      make(map[string][]byte),
+		journal:    journal,
+	}, nil
+}
+
+func (w *dirBackupWriter) HasBlob(blob utils.BlobRef) bool {
+	// Only a shared repository or an earlier attempt of a resumed backup can already hold a blob
+	return (w.shared || w.journal.verified(blob.FileName())) && repositoryHasBlob(w.blobsDir, blob)
+}
+
+// repositoryHasBlob reports whether the repository blobs directory already holds blob.
+// Blobs are content-addressed, so the right size is enough.
+func repositoryHasBlob(blobsDir string, blob utils.BlobRef) bool {
+	info, err := os.Stat(filepath.Join(blobsDir, blob.FileName()))
+	return err == nil && (blob.Size == 0 || info.Size() == blob.Size)
+}
+
+func (w *dirBackupWriter) WriteBlob(fileName string, blob utils.BlobRef, open blobOpener) error {
+	if err := w.journal.setBlob(fileName, journalBlobCopying); err != nil {
+		return err
+	}
+	if err := writeBlobFile(filepath.Join(w.blobsDir, fileName), blob, open, w.journal != nil); err != nil {
+		return err
+	}
+	return w.journal.setBlob(fileName, journalBlobVerified)
+}
+
+func (w *dirBackupWriter) WriteFile(name string, data []byte) error {
+	w.files[name] = data
+	return nil
+}
+
+// Close writes the deferred files, the manifests before backup.json
+func (w *dirBackupWriter) Close() error {
+	names := make([]string, 0, len(w.files))
+	for name := range w.files {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if (names[i] == backupMetadataFile) != (names[j] == backupMetadataFile) {
+			return names[j] == backupMetadataFile
+		}
+		return names[i] < names[j]
+	})
+
+	for _, name := range names {
+		filePath := filepath.Join(w.backupPath, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+			return fmt.Errorf("failed to create directory for %s: %w", name, err)
+		}
+		if err := writeFileAtomic(filePath, w.files[name]); err != nil {
+			return fmt.Errorf("failed to write %s: %w", name, err)
+		}
+	}
+	w.journal.remove()
+	return nil
+}
+
+// Abort removes the backup, unless it is resumable: then everything is kept for the next attempt.
+// A repository snapshot is removed together with its blob references and the blobs only it referenced.
+func (w *dirBackupWriter) Abort() {
+	if w.journal != nil {
+		w.journal.fail()
+		fmt.Fprintf(os.Stderr, "Kept the incomplete backup in %s with %d verified blob(s); run the backup again with --resume to continue it\n", w.backupPath, w.journal.verifiedCount())
+		return
+	}
+	if w.shared {
+		if _, err := releaseSnapshot(filepath.Dir(w.blobsDir), filepath.Base(w.backupPath)); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to remove incomplete snapshot %s: %v\n", w.backupPath, err)
+		}
+		return
+	}
+	os.RemoveAll(w.backupPath)
+}
+
+// newArchiveBackupWriter creates the writer for an archive in the given format
+func newArchiveBackupWriter(out archiveOutput, format string, c compression) (backupWriter, error) {
+	if format == backupFormatZip {
+		return newZipBackupWriter(out, c), nil
+	}
+	return newTarBackupWriter(out, format, c)
+}
+
+// archiveOutput is the destination an archive writer streams into
+type archiveOutput interface {
+	io.Writer
+	// Commit makes the complete archive available
+	Commit() error
+	// Discard throws away an incomplete archive
+	Discard()
+}
+
+// fileArchiveOutput writes an archive to a temporary file that is only renamed to its final name on Commit,
+// so an incomplete archive is never mistaken for a backup
+type fileArchiveOutput struct {
+	path string
+	file *os.File
+}
+
+// createArchiveFile creates the temporary file for the archive at archivePath, which must not exist yet
+func createArchiveFile(archivePath string) (*fileArchiveOutput, error) {
+	if err := os.MkdirAll(filepath.Dir(archivePath), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create backup directory: %w", err)
+	}
+	if _, err := os.Lstat(archivePath); err == nil {
+		return nil, fmt.Errorf("backup %s already exists", archivePath)
+	}
+	file, err := os.OpenFile(archivePath+".partial", os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+	if os.IsExist(err) {
+		return nil, fmt.Errorf("backup %s is already being written", archivePath)
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to create archive file: %w", err)
+	}
+	return &fileArchiveOutput{path: archivePath, file: file}, nil
+}
+
+func (o *fileArchiveOutput) Write(p []byte) (int, error) {
+	return o.file.Write(p)
+}
+
+func (o *fileArchiveOutput) Commit() error {
+	if err := o.file.Close(); err != nil {
+		return fmt.Errorf("failed to close archive file: %w", err)
+	}
+	// Another backup may have taken the name while the archive was written; rename would replace it
+	if _, err := os.Lstat(o.path); err == nil {
+		return fmt.Errorf("backup %s already exists", o.path)
+	}
+	if err := os.Rename(o.file.Name(), o.path); err != nil {
+		return fmt.Errorf("failed to rename archive file: %w", err)
+	}
+	return nil
+}
+
+func (o *fileArchiveOutput) Discard() {
+	o.file.Close()
+	os.Remove(o.file.Name())
+}
+
+// streamArchiveOutput writes an archive to a stream such as stdout.
+// Bytes already sent can't be taken back, but a discarded archive lacks its end-of-archive records,
+// so whoever reads the stream sees it as truncated rather than as a complete backup.
+type streamArchiveOutput struct {
+	io.Writer
+}
+
+func (o streamArchiveOutput) Commit() error {
+	return nil
+}
+
+func (o streamArchiveOutput) Discard() {}
+
+// zipBackupWriter streams a backup directly into a zip archive in a single pass.
+// Model weights are stored as they are, everything else is compressed as configured.
+// archive/zip switches to Zip64 records for entries and offsets beyond 4 GB, which are common for model weights,
+// and writes sizes and checksums after each entry, so the output never needs to be seekable.
+type zipBackupWriter struct {
+	output      archiveOutput
+	writer      *zip.Writer
+	compression compression
+	modTime     time.Time
+}
+
+// newZipBackupWriter creates a zip archive writing to out
+func newZipBackupWriter(out archiveOutput, c compression) *zipBackupWriter {
+	writer := zip.NewWriter(out)
+	c.register(writer)
+
+	return &zipBackupWriter{
+		output:      out,
+		writer:      writer,
+		compression: c,
+		modTime:     time.Now(),
+	}
+}
+
+func (w *zipBackupWriter) HasBlob(blob utils.BlobRef) bool {
+	return false
+}
+
+func (w *zipBackupWriter) WriteBlob(fileName string, blob utils.BlobRef, open blobOpener) error {
+	src, err := open(0)
+	if err != nil {
+		return err
+	}
+	entry, err := w.createEntry(path.Join("blobs", fileName), blob.Size, w.compression.methodFor(blob.MediaType))
+	if err != nil {
+		return err
+	}
+	_, err = copyVerified(entry, src, fileName, blob)
+	return err
+}
+
+func (w *zipBackupWriter) WriteFile(name string, data []byte) error {
+	entry, err := w.createEntry(name, int64(len(data)), w.compression.methodFor(""))
+	if err != nil {
+		return err
+	}
+	_, err = entry.Write(data)
+	return err
+}
+
+// createEntry adds a file entry compressed with method to the archive
+func (w *zipBackupWriter) createEntry(name string, size int64, method uint16) (io.Writer, error) {
+	header := &zip.FileHeader{
+		Name:               name,
+		Method:             method,
+		Modified:           w.modTime,
+		UncompressedSize64: uint64(size),
+	}
+	header.SetMode(0644)
+
+	entry, err := w.writer.CreateHeader(header)
+	if err != nil {
+		return nil, fmt.Errorf("failed to add %s to zip: %w", name, err)
+	}
+	return entry, nil
+}
+
+// Close writes the central directory and commits the archive
+func (w *zipBackupWriter) Close() error {
+	if err := w.writer.Close(); err != nil {
+		return fmt.Errorf("failed to finish zip file: %w", err)
+	}
+	return w.output.Commit()
+}
+
+// Abort discards the archive without writing the central directory
+func (w *zipBackupWriter) Abort() {
+	w.output.Discard()
+}
+
+// tarBackupWriter streams a backup directly into a plain, gzip or zstd compressed tar archive in a single pass.
+// Unlike zip, a compressed tar stream is compressed as a whole, including the model weights.
+type tarBackupWriter struct {
+	output     archiveOutput
+	compressor io.WriteCloser // gzip or zstd stream around the tar stream, nil for plain tar
+	writer     *tar.Writer
+	modTime    time.Time
+}
+
+// newTarBackupWriter creates a tar archive in the given format writing to out
+func newTarBackupWriter(out archiveOutput, format string, c compression) (*tarBackupWriter, error) {
+	w := &tarBackupWriter{output: out, modTime: time.Now()}
+
+	var err error
+	switch format {
+	case backupFormatTarGz:
+		w.compressor, err = gzip.NewWriterLevel(out, c.Level)
+	case backupFormatTarZst:
+		w.compressor, err = zstd.NewWriter(out, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(c.Level)))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s stream: %w", format, err)
+	}
+
+	if w.compressor != nil {
+		w.writer = tar.NewWriter(w.compressor)
+	} else {
+		w.writer = tar.NewWriter(out)
+	}
+	return w, nil
+}
+
+func (w *tarBackupWriter) HasBlob(blob utils.BlobRef) bool {
+	return false
+}
+
+// WriteBlob needs the blob size up front for the tar header; entries over 8 GB are written in PAX format
+func (w *tarBackupWriter) WriteBlob(fileName string, blob utils.BlobRef, open blobOpener) error {
+	src, err := open(0)
+	if err != nil {
+		return err
+	}
+	if err := w.writeHeader(path.Join("blobs", fileName), blob.Size); err != nil {
+		return err
+	}
+	_, err = copyVerified(w.writer, src, fileName, blob)
+	return err
+}
+
+func (w *tarBackupWriter) WriteFile(name string, data []byte) error {
+	if err := w.writeHeader(name, int64(len(data))); err != nil {
+		return err
+	}
+	_, err := w.writer.Write(data)
+	return err
+}
+
+// writeHeader starts a regular file entry in the archive
+func (w *tarBackupWriter) writeHeader(name string, size int64) error {
+	header := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Size:     size,
+		Mode:     0644,
+		ModTime:  w.modTime,
+	}
+	if err := w.writer.WriteHeader(header); err != nil {
+		return fmt.Errorf("failed to add %s to tar: %w", name, err)
+	}
+	return nil
+}
+
+// Close writes the end-of-archive records, flushes the compressed stream and commits the archive
+func (w *tarBackupWriter) Close() error {
+	err := w.writer.Close()
+	if err == nil && w.compressor != nil {
+		err = w.compressor.Close()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to finish tar file: %w", err)
+	}
+	return w.output.Commit()
+}
+
+// Abort discards the archive without writing the end-of-archive records
+func (w *tarBackupWriter) Abort() {
+	w.output.Discard()
+}
+
+// copyVerified copies a blob from src to dst while hashing it, and checks the result against the
+// manifest digest and size as well as the "sha256-<hex>" file name.
+// On a mismatch a *utils.DigestMismatchError or *utils.SizeMismatchError is returned.
+func copyVerified(dst io.Writer, src io.Reader, fileName string, blob utils.BlobRef) (int64, error) {
+	expected, err := utils.ExpectedDigest(fileName, blob.Digest)
+	if err != nil {
+		return 0, err
+	}
+
+	written, actual, err := utils.CopyWithDigest(dst, src)
+	if err != nil {
+		return written, err
+	}
+
+	if actual != expected {
+		return written, &utils.DigestMismatchError{Blob: fileName, Expected: expected, Actual: actual}
+	}
+	if blob.Size > 0 && written != blob.Size {
+		return written, &utils.SizeMismatchError{Blob: fileName, Expected: blob.Size, Actual: written}
+	}
+	return written, nil
+}
+
+// writeBlobFile writes the blob read from open to dst, verifying it while it streams.
+// The blob is written to a temporary file that is only renamed to dst once it has been verified,
+// so a corrupted or interrupted copy never appears under the final name. With resume, a temporary
+// file left by an earlier attempt is continued, and kept if the copy fails for any reason but corruption.
+// Otherwise the temporary file is unique to this copy, so backups writing the same blob into a shared
+// repository at once don't write into each other's copy.
+func writeBlobFile(dst string, blob utils.BlobRef, open blobOpener, resume bool) error {
+	tmpPath := dst + ".partial"
+	if !resume {
+		tmpFile, err := os.CreateTemp(filepath.Dir(dst), filepath.Base(dst)+".*.partial")
+		if err != nil {
+			return err
+		}
+		tmpFile.Close()
+		tmpPath = tmpFile.Name()
+	}
+	if err := copyBlobPartial(tmpPath, blob, filepath.Base(dst), open, resume); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, dst)
+}
+
+// copyBlobPartial copies a blob into the temporary file tmpPath, verifying it and syncing it to disk.
+// With resume, the copy continues at the end of an existing temporary file: its bytes are hashed again
+// so the whole blob is verified, and only the rest is read from the source. Unless resume is set or the
+// copy succeeded, the temporary file is removed; a copy that failed verification is always removed.
+func copyBlobPartial(tmpPath string, blob utils.BlobRef, fileName string, open blobOpener, resume bool) (err error) {
+	var offset int64
+	if info, statErr := os.Stat(tmpPath); resume && statErr == nil && info.Size() <= blob.Size {
+		offset = info.Size()
+	}
+	destFile, err := os.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := destFile.Close(); err == nil {
+			err = closeErr
+		}
+		// Never leave a corrupted copy behind, and keep an interrupted one only to resume it
+		if err != nil && (!resume || isVerificationError(err)) {
+			os.Remove(tmpPath)
+		}
+	}()
+
+	if err := destFile.Truncate(offset); err != nil {
+		return err
+	}
+	if _, err := destFile.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	src, err := open(offset)
+	if err != nil {
+		return err
+	}
+
+	// The bytes already copied are read back for the digest but not written again
+	copied := io.NewSectionReader(destFile, 0, offset)
+	if _, err := copyVerified(&skipWriter{w: destFile, skip: offset}, io.MultiReader(copied, src), fileName, blob); err != nil {
+		return err
+	}
+	return destFile.Sync()
+}
+
+// skipWriter drops the first skip bytes written to it and passes the rest on to w
+type skipWriter struct {
+	w    io.Writer
+	skip int64
+}
+
+func (s *skipWriter) Write(p []byte) (int, error) {
+	if s.skip >= int64(len(p)) {
+		s.skip -= int64(len(p))
+		return len(p), nil
+	}
+	skipped := int(s.skip)
+	s.skip = 0
+	n, err := s.w.Write(p[skipped:])
+	return skipped + n, err
+}
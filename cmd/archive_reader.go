@@ -0,0 +1,348 @@
+package cmd
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"backup_ollama/internal/utils"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// archiveEntry is a single file read from a backup archive
+type archiveEntry struct {
+	Name   string      // Path inside the archive, using '/' as separator
+	Mode   os.FileMode // File mode including the type bits, e.g. os.ModeDir or os.ModeSymlink
+	Size   int64       // Uncompressed size of the entry
+	Reader io.Reader   // Content of the entry, only valid until the next call to Next
+}
+
+// archiveReader reads the entries of a backup archive in the order they were written.
+// backupModel writes backup.json and the manifest before the blobs, so they can be read without
+// going through the whole archive.
+type archiveReader interface {
+	// Next returns the next entry, or io.EOF after the last one
+	Next() (*archiveEntry, error)
+	// Close releases the archive
+	Close() error
+}
+
+// Magic numbers used to detect the format of an archive from its first bytes
+var (
+	zipMagic  = []byte("PK\x03\x04")
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	tarMagic  = []byte("ustar")
+)
+
+// tarMagicOffset is the offset of the magic field in a tar header
+const tarMagicOffset = 257
+
+// detectArchiveFormat returns the archive format of data starting with header, or an empty string if it
+// is not a supported archive. Compressed streams are assumed to contain a tar archive.
+func detectArchiveFormat(header []byte) string {
+	switch {
+	case bytes.HasPrefix(header, zipMagic):
+		return backupFormatZip
+	case bytes.HasPrefix(header, gzipMagic):
+		return backupFormatTarGz
+	case bytes.HasPrefix(header, zstdMagic):
+		return backupFormatTarZst
+	case len(header) >= tarMagicOffset+len(tarMagic) && bytes.Equal(header[tarMagicOffset:tarMagicOffset+len(tarMagic)], tarMagic):
+		return backupFormatTar
+	}
+	return ""
+}
+
+// openBackupArchive opens the backup archive at archivePath, detecting its format from its content
+// rather than its name. It returns the reader and the detected format.
+func openBackupArchive(archivePath string) (archiveReader, string, error) {
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to open archive: %w", err)
+	}
+
+	header := make([]byte, tarMagicOffset+len(tarMagic))
+	n, err := io.ReadFull(file, header)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		file.Close()
+		return nil, "", fmt.Errorf("failed to read archive: %w", err)
+	}
+	format := detectArchiveFormat(header[:n])
+
+	switch format {
+	case "":
+		file.Close()
+		return nil, "", fmt.Errorf("%s is not a zip, tar, tar.gz or tar.zst archive", archivePath)
+	case backupFormatZip:
+		// Zip archives are read through their central directory
+		file.Close()
+		reader, err := zip.OpenReader(archivePath)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to open zip file: %w", err)
+		}
+		return newZipArchiveReader(reader), format, nil
+	}
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		file.Close()
+		return nil, "", fmt.Errorf("failed to read archive: %w", err)
+	}
+	reader, _, err := newArchiveStreamReader(file, file)
+	if err != nil {
+		file.Close()
+		return nil, "", err
+	}
+	return reader, format, nil
+}
+
+// newArchiveStreamReader reads a tar, tar.gz or tar.zst archive sequentially from r, such as stdin,
+// detecting its format from the first bytes. source, if not nil, is closed together with the reader.
+// Zip archives keep their directory at the end and can't be read from a stream.
+func newArchiveStreamReader(r io.Reader, source io.Closer) (archiveReader, string, error) {
+	buffered := bufio.NewReader(r)
+	header, err := buffered.Peek(tarMagicOffset + len(tarMagic))
+	if err != nil && err != io.EOF {
+		return nil, "", fmt.Errorf("failed to read archive: %w", err)
+	}
+
+	format := detectArchiveFormat(header)
+	switch format {
+	case "":
+		return nil, "", fmt.Errorf("not a tar, tar.gz or tar.zst archive")
+	case backupFormatZip:
+		return nil, "", fmt.Errorf("zip archives can't be read from a stream since their directory is at the end; use --format tar, tar.gz or tar.zst")
+	}
+
+	reader, err := newTarArchiveReader(buffered, format, source)
+	if err != nil {
+		return nil, "", err
+	}
+	return reader, format, nil
+}
+
+// zipArchiveReader reads the entries of a zip archive, all other files before the blobs
+type zipArchiveReader struct {
+	archive *zip.ReadCloser
+	files   []*zip.File
+	index   int
+	current io.ReadCloser
+}
+
+// newZipArchiveReader orders the entries of a zip archive so backup.json and the manifests come before the blobs,
+// even in archives written by older versions that stored the blobs first
+func newZipArchiveReader(archive *zip.ReadCloser) *zipArchiveReader {
+	files := append([]*zip.File(nil), archive.File...)
+	sort.SliceStable(files, func(i, j int) bool {
+		return !isBlobEntry(files[i].Name) && isBlobEntry(files[j].Name)
+	})
+	return &zipArchiveReader{archive: archive, files: files}
+}
+
+// isBlobEntry reports whether an archive entry lies below blobs/
+func isBlobEntry(name string) bool {
+	return strings.HasPrefix(name, "blobs/")
+}
+
+func (r *zipArchiveReader) Next() (*archiveEntry, error) {
+	r.closeCurrent()
+	if r.index >= len(r.files) {
+		return nil, io.EOF
+	}
+	file := r.files[r.index]
+	r.index++
+
+	entry := &archiveEntry{
+		Name: file.Name,
+		Mode: file.Mode(),
+		// The 64-bit size is set from the Zip64 extra field for entries over 4 GB
+		Size: int64(file.UncompressedSize64),
+	}
+	if entry.Mode.IsRegular() {
+		content, err := file.Open()
+		if err != nil {
+			return nil, fmt.Errorf("failed to open %s in zip: %w", file.Name, err)
+		}
+		r.current = content
+		entry.Reader = content
+	} else {
+		entry.Reader = bytes.NewReader(nil)
+	}
+	return entry, nil
+}
+
+func (r *zipArchiveReader) closeCurrent() {
+	if r.current != nil {
+		r.current.Close()
+		r.current = nil
+	}
+}
+
+func (r *zipArchiveReader) Close() error {
+	r.closeCurrent()
+	return r.archive.Close()
+}
+
+// tarArchiveReader reads the entries of a plain or compressed tar stream
+type tarArchiveReader struct {
+	reader       *tar.Reader
+	decompressor io.Closer // gzip or zstd stream around the tar stream, nil for plain tar
+	source       io.Closer // Underlying file, may be nil
+}
+
+// newTarArchiveReader reads a tar stream in the given format from src and closes source (if not nil) on Close
+func newTarArchiveReader(src io.Reader, format string, source io.Closer) (*tarArchiveReader, error) {
+	r := &tarArchiveReader{source: source}
+	switch format {
+	case backupFormatTar:
+		r.reader = tar.NewReader(src)
+	case backupFormatTarGz:
+		gz, err := gzip.NewReader(src)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open gzip stream: %w", err)
+		}
+		r.decompressor = gz
+		r.reader = tar.NewReader(gz)
+	case backupFormatTarZst:
+		zr, err := zstd.NewReader(src)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open zstd stream: %w", err)
+		}
+		rc := zr.IOReadCloser()
+		r.decompressor = rc
+		r.reader = tar.NewReader(rc)
+	default:
+		return nil, fmt.Errorf("unsupported archive format: %s", format)
+	}
+	return r, nil
+}
+
+func (r *tarArchiveReader) Next() (*archiveEntry, error) {
+	header, err := r.reader.Next()
+	if err == io.EOF {
+		return nil, io.EOF
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tar archive: %w", err)
+	}
+
+	mode := header.FileInfo().Mode()
+	switch header.Typeflag {
+	case tar.TypeReg, tar.TypeDir, tar.TypeSymlink, tar.TypeChar, tar.TypeBlock, tar.TypeFifo:
+	default:
+		// Hard links, sparse files and other entry types report a regular file mode but aren't plain files
+		mode |= os.ModeIrregular
+	}
+
+	return &archiveEntry{
+		Name:   header.Name,
+		Mode:   mode,
+		Size:   header.Size,
+		Reader: r.reader,
+	}, nil
+}
+
+func (r *tarArchiveReader) Close() error {
+	var err error
+	if r.decompressor != nil {
+		err = r.decompressor.Close()
+	}
+	if r.source != nil {
+		if closeErr := r.source.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// readArchiveEntry reads the whole content of a (small) archive entry such as a manifest or backup.json
+func readArchiveEntry(entry *archiveEntry) ([]byte, error) {
+	content, err := io.ReadAll(entry.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s from archive: %w", entry.Name, err)
+	}
+	return content, nil
+}
+
+// validateArchiveEntry rejects archive entries that a backup never contains and that could write outside
+// the directory a backup is restored to: absolute paths, '..' elements, backslashes, symlinks, hard links,
+// device files and other special files, and any path other than backup.json, 'blobs/sha256-<hex>' and
+// 'library/manifests/{registry}/{namespace}/{model}/{version}' (plus their parent directories).
+func validateArchiveEntry(entry *archiveEntry) error {
+	name := entry.Name
+	if entry.Mode.IsDir() {
+		name = strings.TrimSuffix(name, "/")
+	}
+
+	switch {
+	case name == "":
+		return fmt.Errorf("unsafe archive entry with an empty name")
+	case strings.HasPrefix(name, "/") || filepath.IsAbs(name) || filepath.VolumeName(name) != "":
+		return fmt.Errorf("unsafe archive entry '%s': absolute path", entry.Name)
+	case strings.ContainsAny(name, "\\\x00"):
+		return fmt.Errorf("unsafe archive entry '%s': invalid characters in path", entry.Name)
+	case path.Clean(name) != name:
+		return fmt.Errorf("unsafe archive entry '%s': path is not clean", entry.Name)
+	}
+	for _, element := range strings.Split(name, "/") {
+		if element == ".." {
+			return fmt.Errorf("unsafe archive entry '%s': path escapes the backup", entry.Name)
+		}
+	}
+
+	if entry.Mode.IsDir() {
+		// Only the directories leading to blobs and manifests may appear
+		if name == "blobs" || name == "library" || name == "library/manifests" {
+			return nil
+		}
+		rel := strings.TrimPrefix(name, "library/manifests/")
+		if rel != name && strings.Count(rel, "/") < 3 {
+			return nil
+		}
+		return fmt.Errorf("unexpected directory '%s' in backup archive", entry.Name)
+	}
+	if !entry.Mode.IsRegular() {
+		return fmt.Errorf("unsafe archive entry '%s': %s entries are not allowed in a backup", entry.Name, fileTypeName(entry.Mode))
+	}
+
+	switch {
+	case name == backupMetadataFile:
+		return nil
+	case isBlobEntry(name):
+		if fileName := strings.TrimPrefix(name, "blobs/"); utils.FileNameToDigest(fileName) != "" {
+			return nil
+		}
+	case strings.HasPrefix(name, "library/manifests/"):
+		if _, ok := utils.ParseManifestPath(strings.TrimPrefix(name, "library/manifests/")); ok {
+			return nil
+		}
+	}
+	return fmt.Errorf("unexpected file '%s' in backup archive: only %s, blobs/sha256-<hex> and library/manifests/{registry}/{namespace}/{model}/{version} are allowed", entry.Name, backupMetadataFile)
+}
+
+// fileTypeName describes the type of a special file for error messages
+func fileTypeName(mode os.FileMode) string {
+	switch {
+	case mode&os.ModeSymlink != 0:
+		return "symlink"
+	case mode&os.ModeDevice != 0:
+		return "device"
+	case mode&os.ModeNamedPipe != 0:
+		return "named pipe"
+	case mode&os.ModeSocket != 0:
+		return "socket"
+	case mode&os.ModeIrregular != 0:
+		return "hard link or other irregular"
+	}
+	return "special"
+}
@@ -0,0 +1,153 @@
+package cmd
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var testBlobName = "blobs/sha256-" + strings.Repeat("ab", 32)
+
+func TestValidateArchiveEntry(t *testing.T) {
+	tests := []struct {
+		name  string
+		entry archiveEntry
+		ok    bool
+	}{
+		// Everything a backup contains
+		{name: "metadata", entry: archiveEntry{Name: "backup.json"}, ok: true},
+		{name: "blob", entry: archiveEntry{Name: testBlobName}, ok: true},
+		{name: "manifest", entry: archiveEntry{Name: "library/manifests/registry.ollama.ai/library/phi3/latest"}, ok: true},
+		{name: "registry with port", entry: archiveEntry{Name: "library/manifests/localhost:5000/team/coder/v1"}, ok: true},
+		{name: "blobs dir", entry: archiveEntry{Name: "blobs/", Mode: os.ModeDir}, ok: true},
+		{name: "manifests dir", entry: archiveEntry{Name: "library/manifests/registry.ollama.ai/library/phi3/", Mode: os.ModeDir}, ok: true},
+
+		// Paths escaping the backup
+		{name: "parent", entry: archiveEntry{Name: "../backup.json"}},
+		{name: "parent in blobs", entry: archiveEntry{Name: "blobs/../../" + strings.TrimPrefix(testBlobName, "blobs/")}},
+		{name: "parent in manifest", entry: archiveEntry{Name: "library/manifests/registry.ollama.ai/../../../../etc/passwd"}},
+		{name: "parent at end", entry: archiveEntry{Name: "library/manifests/registry.ollama.ai/library/phi3/.."}},
+		{name: "parent dir", entry: archiveEntry{Name: "../", Mode: os.ModeDir}},
+		{name: "absolute", entry: archiveEntry{Name: "/etc/passwd"}},
+		{name: "absolute blob", entry: archiveEntry{Name: "/" + testBlobName}},
+		{name: "absolute dir", entry: archiveEntry{Name: "/tmp/", Mode: os.ModeDir}},
+		{name: "windows drive", entry: archiveEntry{Name: "C:/Windows/system.ini"}},
+		{name: "backslash", entry: archiveEntry{Name: "blobs\\..\\..\\evil"}},
+		{name: "nul", entry: archiveEntry{Name: "backup.json\x00.txt"}},
+		{name: "not clean", entry: archiveEntry{Name: "blobs//" + strings.TrimPrefix(testBlobName, "blobs/")}},
+		{name: "dot", entry: archiveEntry{Name: "./backup.json"}},
+		{name: "empty", entry: archiveEntry{Name: ""}},
+
+		// Special files, even at names a backup uses
+		{name: "symlink", entry: archiveEntry{Name: "backup.json", Mode: os.ModeSymlink}},
+		{name: "symlink blob", entry: archiveEntry{Name: testBlobName, Mode: os.ModeSymlink | 0777}},
+		{name: "hard link", entry: archiveEntry{Name: testBlobName, Mode: os.ModeIrregular}},
+		{name: "device", entry: archiveEntry{Name: testBlobName, Mode: os.ModeDevice}},
+		{name: "char device", entry: archiveEntry{Name: "backup.json", Mode: os.ModeDevice | os.ModeCharDevice}},
+		{name: "named pipe", entry: archiveEntry{Name: "backup.json", Mode: os.ModeNamedPipe}},
+		{name: "socket", entry: archiveEntry{Name: "backup.json", Mode: os.ModeSocket}},
+
+		// Files and directories a backup never contains
+		{name: "other file", entry: archiveEntry{Name: "evil.sh"}},
+		{name: "file in library", entry: archiveEntry{Name: "library/evil"}},
+		{name: "blob name", entry: archiveEntry{Name: "blobs/evil"}},
+		{name: "short digest", entry: archiveEntry{Name: "blobs/sha256-abcd"}},
+		{name: "nested blob", entry: archiveEntry{Name: "blobs/x/" + strings.TrimPrefix(testBlobName, "blobs/")}},
+		{name: "short manifest path", entry: archiveEntry{Name: "library/manifests/registry.ollama.ai/library/phi3"}},
+		{name: "long manifest path", entry: archiveEntry{Name: "library/manifests/registry.ollama.ai/library/phi3/latest/x"}},
+		{name: "other dir", entry: archiveEntry{Name: "etc/", Mode: os.ModeDir}},
+		{name: "dir below manifest", entry: archiveEntry{Name: "library/manifests/r/n/m/v/", Mode: os.ModeDir}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateArchiveEntry(&tt.entry)
+			if tt.ok && err != nil {
+				t.Errorf("validateArchiveEntry(%q, %v): %v", tt.entry.Name, tt.entry.Mode, err)
+			} else if !tt.ok && err == nil {
+				t.Errorf("validateArchiveEntry(%q, %v) accepted an unsafe entry", tt.entry.Name, tt.entry.Mode)
+			}
+		})
+	}
+}
+
+// TestValidateTarEntryTypes checks that special entries are rejected as read from a tar archive, where hard
+// links and devices carry the name of a regular backup file
+func TestValidateTarEntryTypes(t *testing.T) {
+	headers := []*tar.Header{
+		{Name: "backup.json", Typeflag: tar.TypeSymlink, Linkname: "/etc/passwd"},
+		{Name: testBlobName, Typeflag: tar.TypeLink, Linkname: "/etc/shadow"},
+		{Name: testBlobName, Typeflag: tar.TypeChar, Devmajor: 1, Devminor: 3},
+		{Name: testBlobName, Typeflag: tar.TypeBlock, Devmajor: 8},
+		{Name: "backup.json", Typeflag: tar.TypeFifo},
+	}
+
+	var buf bytes.Buffer
+	w := tar.NewWriter(&buf)
+	for _, header := range headers {
+		header.Mode = 0644
+		if err := w.WriteHeader(header); err != nil {
+			t.Fatalf("WriteHeader(%s): %v", header.Name, err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	reader, _, err := newArchiveStreamReader(&buf, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	for _, header := range headers {
+		entry, err := reader.Next()
+		if err != nil {
+			t.Fatalf("Next: %v", err)
+		}
+		if err := validateArchiveEntry(entry); err == nil {
+			t.Errorf("tar entry %s of type %q was accepted", entry.Name, header.Typeflag)
+		}
+	}
+	if _, err := reader.Next(); err != io.EOF {
+		t.Errorf("expected the end of the archive, got %v", err)
+	}
+}
+
+// TestValidateZipEntryTypes checks that a symlink stored in a zip archive is rejected
+func TestValidateZipEntryTypes(t *testing.T) {
+	archivePath := filepath.Join(t.TempDir(), "evil.zip")
+	file, err := os.Create(archivePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := zip.NewWriter(file)
+	header := &zip.FileHeader{Name: "backup.json"}
+	header.SetMode(os.ModeSymlink | 0777)
+	link, err := w.CreateHeader(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	link.Write([]byte("/etc/passwd"))
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	reader, _, err := openBackupArchive(archivePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	entry, err := reader.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := validateArchiveEntry(entry); err == nil {
+		t.Errorf("zip symlink %s was accepted", entry.Name)
+	}
+}
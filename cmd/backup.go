@@ -1,13 +1,16 @@
 package cmd
 
 import (
-	"archive/zip"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"text/tabwriter"
 	"time"
 
 	"backup_ollama/internal/utils"
@@ -17,86 +20,463 @@ import (
 
 var backupDir string
 var createZip bool
+var useRepository bool
+var backupAll bool
+var backupRegexps []string
+var backupCompression string
+var backupFormat string
+var backupOutput string
+var backupDryRun string
+var backupJobs int
+var backupBandwidthLimit string
+var backupProgress string
+var backupResume bool
+var backupIncremental bool
+
+// backupStream receives the archive when backing up to stdout with --output -, nil otherwise
+var backupStream io.Writer
+
+// backupArchiveCompression is the compression of archive backups, resolved from --format and --compression
+var backupArchiveCompression compression
 
 // backupCmd represents the backup command
 var backupCmd = &cobra.Command{
-	Use:   "backup [model name]",
-	Short: "Backup a specified model",
-	Long:  `This command allows you to backup a specified model to a designated directory.`,
-	Args:  cobra.ExactArgs(1),
+	Use:   "backup [model name...]",
+	Short: "Backup one or more models",
+	Long: `This command allows you to backup one or more models to a designated directory.
+
+Models can be given by name ('[[registry/]namespace/]model[:version]'), by glob
+pattern over registry, namespace, model and version (e.g. 'llama3*:*-q4*'), by
+regular expression over 'registry/namespace/model:version' with --regex, or all at once
+with --all. All selected models are backed up in one run with a summary of
+successes and failures.
+
+With --output - a single model is written to stdout as one archive (tar unless
+--format says otherwise), e.g. to pipe it into 'ssh host backup_ollama restore -'.
+Progress messages go to stderr in that case.`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) == 0 && !backupAll && len(backupRegexps) == 0 {
+			return fmt.Errorf("requires at least one model name, --all or --regex")
+		}
+		return nil
+	},
 	Run: func(cmd *cobra.Command, args []string) {
-		modelName := args[0]
-		if err := backupModel(modelName, backupDir); err != nil {
-			fmt.Fprintf(os.Stderr, "Error backing up model: %v\n", err)
+		dryRunFormat := ""
+		if cmd.Flags().Changed("dry-run") {
+			var err error
+			if dryRunFormat, err = parseDryRun(backupDryRun); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			if dryRunFormat == "json" {
+				// Keep the JSON on stdout free of status messages
+				statusOut = os.Stderr
+			}
+		}
+
+		if err := setTransferLimits(backupJobs, backupBandwidthLimit); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		if err := setProgressMode(backupProgress); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		if err := resolveBackupOutput(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		if err := resolveBackupFormat(cmd); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		if backupResume && (backupStream != nil || backupFormat != "") {
+			fmt.Fprintf(os.Stderr, "Error: --resume continues directory and repository backups; archives are written in a single pass and can't be resumed\n")
+			os.Exit(1)
+		}
+
+		if backupIncremental && backupStream != nil {
+			fmt.Fprintf(os.Stderr, "Error: --incremental compares models against the backups in --dir and can't be used with --output -\n")
+			os.Exit(1)
+		}
+
+		modelNames, err := resolveBackupTargets(args, backupAll, backupRegexps)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error selecting models: %v\n", err)
+			os.Exit(1)
+		}
+
+		// An incremental run only backs up models whose manifest changed since their newest backup
+		var unchanged []backupResult
+		if backupIncremental {
+			if modelNames, unchanged, err = skipUnchangedModels(modelNames, backupDir); err != nil {
+				fmt.Fprintf(os.Stderr, "Error comparing models with their backups: %v\n", err)
+				os.Exit(1)
+			}
+			if len(modelNames) == 0 {
+				fmt.Fprintf(statusOut, "All %d selected model(s) are unchanged since their last backup, nothing to back up\n", len(unchanged))
+				return
+			}
+		}
+
+		if dryRunFormat != "" {
+			// The plan goes to stdout even with --output -
+			if err := dryRunBackup(os.Stdout, modelNames, backupDir, dryRunFormat); err != nil {
+				fmt.Fprintf(os.Stderr, "Error planning backup: %v\n", err)
+				os.Exit(1)
+			}
+			return
+		}
+
+		if backupStream != nil {
+			if len(modelNames) != 1 {
+				fmt.Fprintf(os.Stderr, "Error: --output - writes a single archive and requires exactly one model, %d selected\n", len(modelNames))
+				os.Exit(1)
+			}
+			if err := backupModel(modelNames[0], backupDir, time.Now()); err != nil {
+				fmt.Fprintf(os.Stderr, "Error backing up model: %v\n", err)
+				os.Exit(1)
+			}
+			fmt.Fprintf(statusOut, "Model '%s' backed up successfully to stdout\n", modelNames[0])
+			return
+		}
+
+		// A single model keeps the plain output of a single backup
+		if len(modelNames) == 1 && len(unchanged) == 0 {
+			modelName := modelNames[0]
+			if err := backupModel(modelName, backupDir, time.Now()); err != nil {
+				fmt.Fprintf(os.Stderr, "Error backing up model: %v\n", err)
+				os.Exit(1)
+			}
+			fmt.Fprintf(statusOut, "Model '%s' backed up successfully to '%s'\n", modelName, backupDir)
+			return
+		}
+
+		results := append(unchanged, backupModels(modelNames, backupDir)...)
+		if failed := printBackupSummary(results); failed > 0 {
 			os.Exit(1)
 		}
-		fmt.Printf("Model '%s' backed up successfully to '%s'\n", modelName, backupDir)
 	},
 }
 
 func init() {
 	rootCmd.AddCommand(backupCmd)
 	backupCmd.Flags().StringVarP(&backupDir, "dir", "d", "./backup", "Directory to save the backup")
-	backupCmd.Flags().BoolVarP(&createZip, "zip", "z", false, "Create a zip file of the backup and delete the original directory")
+	backupCmd.Flags().StringVarP(&backupOutput, "output", "o", "", "Write the backup archive to stdout instead of --dir ('-')")
+	backupCmd.Flags().BoolVarP(&createZip, "zip", "z", false, "Write the backup as a zip file (same as --format zip)")
+	backupCmd.Flags().StringVar(&backupFormat, "format", "", "Write the backup as a single archive streamed directly from the Ollama blob store: tar, tar.gz, tar.zst or zip")
+	backupCmd.Flags().StringVar(&backupCompression, "compression", "deflate", "Compression of zip entries other than model weights, which are always stored: store, deflate[:1-9] or zstd[:1-22]; for tar.gz and tar.zst the level of the whole stream")
+	backupCmd.Flags().BoolVar(&useRepository, "repo", false, "Store the backup as a snapshot in a deduplicated repository at --dir (implied if --dir already is a repository)")
+	backupCmd.Flags().BoolVarP(&backupAll, "all", "a", false, "Backup all models")
+	backupCmd.Flags().StringArrayVar(&backupRegexps, "regex", nil, "Backup models whose 'registry/namespace/model:version' matches this regular expression (can be repeated)")
+	backupCmd.Flags().IntVarP(&backupJobs, "jobs", "j", 1, "Number of blobs copied at once into a backup directory or repository; archives are written one blob at a time")
+	backupCmd.Flags().StringVar(&backupBandwidthLimit, "bwlimit", "", "Cap the combined copy rate of all jobs in bytes per second, e.g. 500K, 100M or 1.5G")
+	backupCmd.Flags().BoolVar(&backupIncremental, "incremental", false, "Skip models whose manifest is unchanged since their newest backup in --dir")
+	backupCmd.Flags().BoolVar(&backupResume, "resume", false, "Continue the newest incomplete backup of each model instead of starting over, and keep the backup if it fails again")
+	backupCmd.Flags().StringVar(&backupProgress, "progress", progressAuto, "Report copy progress on stderr: auto (a bar on a terminal, log lines otherwise), bar, log, json or none")
+	backupCmd.Flags().StringVar(&backupDryRun, "dry-run", "", "Print what would be backed up without writing anything, as text or json")
+	backupCmd.Flags().Lookup("dry-run").NoOptDefVal = "text"
+}
+
+// resolveBackupOutput sets up --output -. The archive goes to stdout, so the status messages of the
+// backup are sent to stderr to keep the archive stream intact.
+func resolveBackupOutput() error {
+	switch backupOutput {
+	case "":
+		return nil
+	case "-":
+	default:
+		return fmt.Errorf("invalid output '%s': only '-' (stdout) is supported, use --dir to write to a directory", backupOutput)
+	}
+
+	if useRepository {
+		return fmt.Errorf("--output - cannot be used with a backup repository")
+	}
+	if backupFormat == "" && !createZip {
+		backupFormat = backupFormatTar
+	}
+
+	backupStream = os.Stdout
+	statusOut = os.Stderr
+	return nil
+}
+
+// resolveBackupFormat checks --format, --zip and --compression and resolves the archive compression.
+// --zip is kept as a shorthand for --format zip; without either, the backup is written as a directory.
+func resolveBackupFormat(cmd *cobra.Command) error {
+	if createZip {
+		if backupFormat != "" && backupFormat != backupFormatZip {
+			return fmt.Errorf("--zip cannot be combined with --format %s", backupFormat)
+		}
+		backupFormat = backupFormatZip
+	}
+
+	format := backupFormatDirectory
+	if backupFormat != "" {
+		valid := false
+		for _, f := range archiveFormats {
+			valid = valid || f == backupFormat
+		}
+		if !valid {
+			return fmt.Errorf("invalid format '%s': expected %s", backupFormat, strings.Join(archiveFormats, ", "))
+		}
+		format = backupFormat
+	}
+
+	var err error
+	backupArchiveCompression, err = archiveCompression(format, backupCompression, cmd.Flags().Changed("compression"))
+	return err
+}
+
+// backupResult records the outcome of backing up a single model during a backup run
+type backupResult struct {
+	Model     string
+	Err       error
+	Unchanged string // Backup the model is unchanged since, if an incremental run skipped it
+}
+
+// resolveBackupTargets turns the command line selection into the list of model names to back up.
+// Plain names are passed through unchanged so validateModelName can resolve them, while glob patterns,
+// regular expressions and --all are expanded against the enumerated models.
+func resolveBackupTargets(args []string, all bool, regexps []string) ([]string, error) {
+	var names []string
+	var globs []string
+	for _, arg := range args {
+		if utils.IsGlobPattern(arg) {
+			globs = append(globs, arg)
+		} else {
+			names = append(names, arg)
+		}
+	}
+
+	if all || len(globs) > 0 || len(regexps) > 0 {
+		modelList, err := utils.EnumerateOllamaModels()
+		if err != nil {
+			return nil, fmt.Errorf("failed to enumerate models: %w", err)
+		}
+
+		var refs []utils.ModelRef
+		if all {
+			refs = modelList.Refs()
+		} else {
+			refs, err = utils.FilterModels(modelList, globs, regexps)
+			if err != nil {
+				return nil, err
+			}
+			if len(refs) == 0 {
+				return nil, fmt.Errorf("no models match the given patterns")
+			}
+		}
+
+		for _, ref := range refs {
+			names = append(names, ref.String())
+		}
+	}
+
+	// Remove duplicates while keeping the order
+	seen := make(map[string]bool)
+	unique := make([]string, 0, len(names))
+	for _, name := range names {
+		if !seen[name] {
+			seen[name] = true
+			unique = append(unique, name)
+		}
+	}
+
+	if len(unique) == 0 {
+		return nil, fmt.Errorf("no models selected")
+	}
+	return unique, nil
+}
+
+// dryRunBackup prints the plan of backing up every model in modelNames to out without writing anything
+func dryRunBackup(out io.Writer, modelNames []string, dir, format string) error {
+	backupTime := time.Now()
+	plans := make([]*transferPlan, 0, len(modelNames))
+	for _, modelName := range modelNames {
+		plan, err := backupTransferPlan(modelName, dir, backupTime)
+		if err != nil {
+			return fmt.Errorf("model '%s': %w", modelName, err)
+		}
+		plans = append(plans, plan)
+	}
+	return outputTransferPlans(out, plans, format)
+}
+
+// skipUnchangedModels compares every model in modelNames with its newest backup in dir, in any format, and
+// splits off the models whose installed manifest has the same digest as the backed up one. It returns the
+// models to back up, fully qualified, and a result for each unchanged model. A model that can't be resolved
+// is kept so the backup reports the error.
+func skipUnchangedModels(modelNames []string, dir string) ([]string, []backupResult, error) {
+	newest := make(map[string]backupEntry)
+	if _, err := os.Stat(dir); err == nil {
+		backups, err := scanBackups(dir)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, backup := range backups {
+			key := backup.Ref.String()
+			if current, ok := newest[key]; !ok || backup.CreatedAt.After(current.CreatedAt) {
+				newest[key] = backup
+			}
+		}
+	}
+
+	var changed []string
+	var unchanged []backupResult
+	for _, modelName := range modelNames {
+		ref, version, err := validateModelName(modelName)
+		if err != nil {
+			changed = append(changed, modelName)
+			continue
+		}
+
+		backup, ok := newest[ref.String()]
+		switch {
+		case !ok:
+			fmt.Fprintf(statusOut, "Backing up '%s': no backup yet\n", ref)
+		case version.ManifestDigest == "" || backup.ManifestDigest != version.ManifestDigest:
+			fmt.Fprintf(statusOut, "Backing up '%s': changed since backup %s\n", ref, backup.Name)
+		default:
+			fmt.Fprintf(statusOut, "Skipping '%s': unchanged since backup %s\n", ref, backup.Name)
+			unchanged = append(unchanged, backupResult{Model: ref.String(), Unchanged: backup.Name})
+			continue
+		}
+		changed = append(changed, ref.String())
+	}
+	return changed, unchanged, nil
+}
+
+// backupModels backs up every model in modelNames as one run sharing the same backup version.
+// A failing model doesn't stop the run; every outcome is returned.
+func backupModels(modelNames []string, dir string) []backupResult {
+	backupTime := time.Now()
+	results := make([]backupResult, 0, len(modelNames))
+
+	for i, modelName := range modelNames {
+		fmt.Fprintf(statusOut, "\n[%d/%d] Backing up '%s'\n", i+1, len(modelNames), modelName)
+		err := backupModel(modelName, dir, backupTime)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error backing up model '%s': %v\n", modelName, err)
+		}
+		results = append(results, backupResult{Model: modelName, Err: err})
+	}
+
+	return results
+}
+
+// printBackupSummary prints a table with the outcome of every model in a backup run
+// and returns the number of failed backups
+func printBackupSummary(results []backupResult) int {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+
+	failed := 0
+	fmt.Fprintf(w, "\nBackup summary:\n")
+	fmt.Fprintf(w, "  %s\t%s\t%s\n", "MODEL", "STATUS", "ERROR")
+	fmt.Fprintf(w, "  %s\t%s\t%s\n", "-----", "------", "-----")
+	unchanged := 0
+	for _, result := range results {
+		switch {
+		case result.Err != nil:
+			failed++
+			fmt.Fprintf(w, "  %s\t%s\t%v\n", result.Model, "FAILED", result.Err)
+		case result.Unchanged != "":
+			unchanged++
+			fmt.Fprintf(w, "  %s\t%s\t\n", result.Model, "UNCHANGED")
+		default:
+			fmt.Fprintf(w, "  %s\t%s\t\n", result.Model, "OK")
+		}
+	}
+	if unchanged > 0 {
+		fmt.Fprintf(w, "\n%d succeeded, %d unchanged, %d failed\n", len(results)-failed-unchanged, unchanged, failed)
+	} else {
+		fmt.Fprintf(w, "\n%d succeeded, %d failed\n", len(results)-failed, failed)
+	}
+	w.Flush()
+
+	return failed
 }
 
 // validateModelName validates the model name string against the enumerated models.
-// Model name is expected in the format '{model}:{version}'.
+// Model name is expected in the format '[[{registry}/]{namespace}/]{model}[:{version}]'.
+// The namespace defaults to 'library'; if no registry is given, the model is looked up in every registry,
+// preferring the default registry if it exists in several.
 // If version is not specified and there's only one version in the directory, it uses the available version.
-// Returns the model name, model version, registry, manifest details and the full path to the manifest if successful, or an error if validation fails.
-func validateModelName(modelName string) (string, string, string, map[string]interface{}, string, error) {
-	// Split the model name into model and version parts
-	parts := strings.Split(modelName, ":")
-	model := parts[0]
-	var version string
-	if len(parts) > 1 {
-		version = parts[1]
+// Returns the fully qualified model reference and the matching model version if successful, or an error if validation fails.
+func validateModelName(modelName string) (utils.ModelRef, *utils.ModelVersion, error) {
+	// Split the model name into registry, namespace, model and version parts
+	ref, err := utils.ParseModelName(modelName)
+	if err != nil {
+		return utils.ModelRef{}, nil, err
+	}
+	if ref.Namespace == "" {
+		ref.Namespace = utils.DefaultNamespace
 	}
+	model := ref.Model
+	version := ref.Version
 
 	// Get all Ollama models
 	modelList, err := utils.EnumerateOllamaModels()
 	if err != nil {
-		return "", "", "", nil, "", fmt.Errorf("failed to enumerate models: %w", err)
+		return utils.ModelRef{}, nil, fmt.Errorf("failed to enumerate models: %w", err)
 	}
 
 	// Look for the specified model in the list
-	var targetModel *utils.Model
-	var registry string
-	for _, reg := range modelList.Registries {
+	var candidates []*utils.Model
+	for r, reg := range modelList.Registries {
+		if ref.Registry != "" && reg.Name != ref.Registry {
+			continue
+		}
 		for i, m := range reg.Models {
-			if m.Name == model {
-				targetModel = &reg.Models[i]
-				registry = reg.Name
-				break
+			if m.Name == model && m.Namespace == ref.Namespace {
+				candidates = append(candidates, &modelList.Registries[r].Models[i])
 			}
 		}
-		if targetModel != nil {
-			break
-		}
 	}
 
-	if targetModel == nil {
-		return "", "", "", nil, "", fmt.Errorf("model '%s' not found", model)
+	if len(candidates) == 0 {
+		return utils.ModelRef{}, nil, fmt.Errorf("model '%s' not found", modelName)
 	}
 
+	targetModel := candidates[0]
+	if len(candidates) > 1 {
+		// The same model exists in several registries, prefer the default one
+		targetModel = nil
+		for _, candidate := range candidates {
+			if candidate.Registry == utils.DefaultRegistry {
+				targetModel = candidate
+			}
+		}
+		if targetModel == nil {
+			fmt.Fprintf(statusOut, "Model '%s' found in multiple registries. Please specify one using the format 'registry/namespace/model:version'.\n", model)
+			fmt.Fprintln(statusOut, "Available registries:")
+			for _, candidate := range candidates {
+				fmt.Fprintf(statusOut, "- %s\n", candidate.Registry)
+			}
+			return utils.ModelRef{}, nil, fmt.Errorf("registry not specified")
+		}
+	}
+	ref.Registry = targetModel.Registry
+
 	// If no version is specified
 	if version == "" {
 		if len(targetModel.Versions) == 0 {
-			return "", "", "", nil, "", fmt.Errorf("no versions found for model '%s'", model)
+			return utils.ModelRef{}, nil, fmt.Errorf("no versions found for model '%s'", model)
 		} else if len(targetModel.Versions) == 1 {
 			// If there's only one version, use it
 			version = targetModel.Versions[0].Name
-			fmt.Printf("Using version '%s' for model '%s'\n", version, model)
+			fmt.Fprintf(statusOut, "Using version '%s' for model '%s'\n", version, model)
 		} else {
 			// If there are multiple versions, list them and ask the user to specify
-			fmt.Printf("Multiple versions found for model '%s'. Please specify a version using the format 'model:version'.\n", model)
-			fmt.Println("Available versions:")
+			fmt.Fprintf(statusOut, "Multiple versions found for model '%s'. Please specify a version using the format 'model:version'.\n", model)
+			fmt.Fprintln(statusOut, "Available versions:")
 			for _, v := range targetModel.Versions {
-				fmt.Printf("- %s\n", v.Name)
+				fmt.Fprintf(statusOut, "- %s\n", v.Name)
 			}
-			return "", "", "", nil, "", fmt.Errorf("version not specified")
+			return utils.ModelRef{}, nil, fmt.Errorf("version not specified")
 		}
 	}
+	ref.Version = version
 
 	// Look for the specified version
 	var targetVersion *utils.ModelVersion
@@ -108,212 +488,381 @@ func validateModelName(modelName string) (string, string, string, map[string]int
 	}
 
 	if targetVersion == nil {
-		return "", "", "", nil, "", fmt.Errorf("version '%s' not found for model '%s'", version, model)
+		return utils.ModelRef{}, nil, fmt.Errorf("version '%s' not found for model '%s'", version, model)
 	}
 
-	// Return the model name, version, registry, manifest details, and full path to the manifest
-	return model, version, registry, targetVersion.Details, targetVersion.Path, nil
+	// Return the fully qualified model reference and the version with its manifest details and path
+	return ref, targetVersion, nil
+}
+
+// backupLabel returns the model part of a backup name: the model name for official models,
+// '{namespace}+{model}' for models from other namespaces and '{registry}+{namespace}+{model}' for models
+// from other registries. Every part is escaped, so '+' only ever separates parts and no two models share a label.
+func backupLabel(ref utils.ModelRef) string {
+	parts := []string{escapeBackupNamePart(ref.Model)}
+	if ref.Namespace != utils.DefaultNamespace || ref.Registry != utils.DefaultRegistry {
+		parts = append([]string{escapeBackupNamePart(ref.Namespace)}, parts...)
+	}
+	if ref.Registry != utils.DefaultRegistry {
+		parts = append([]string{escapeBackupNamePart(ref.Registry)}, parts...)
+	}
+	return strings.Join(parts, "+")
 }
 
-// backupModel performs the actual backup operation
-func backupModel(modelName, dir string) error {
+// escapeBackupNamePart escapes part of a model name for use in a backup name: every byte other than a letter,
+// digit, '.', '_' or '-' becomes '%XX', e.g. the ':' of a registry port, which isn't allowed in Windows file names
+func escapeBackupNamePart(part string) string {
+	var b strings.Builder
+	for i := 0; i < len(part); i++ {
+		c := part[i]
+		if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '.' || c == '_' || c == '-' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// backupModel performs the actual backup operation.
+// backupTime determines the backup version, so models backed up in the same run share it.
+func backupModel(modelName, dir string, backupTime time.Time) error {
 	// Validate the model name and get the model information
-	model, version, registry, manifest, manifestPath, err := validateModelName(modelName)
+	ref, targetVersion, err := validateModelName(modelName)
 	if err != nil {
 		return err
 	}
+	model, version, registry := ref.Model, ref.Version, ref.Registry
 
-	fmt.Printf("Found model: %s, version: %s, registry: %s, manifest path: %s\n", model, version, registry, manifestPath)
+	// Read the raw manifest once: its bytes are stored untouched so its digest stays valid,
+	// and the blobs to copy are taken from exactly these bytes
+	manifestData, err := os.ReadFile(targetVersion.Path)
+	if err != nil {
+		return fmt.Errorf("failed to read manifest: %w", err)
+	}
+	var manifest map[string]interface{}
+	if err := json.Unmarshal(manifestData, &manifest); err != nil {
+		return fmt.Errorf("failed to parse manifest: %w", err)
+	}
+	manifestDigest := utils.BytesDigest(manifestData)
 
-	// Generate a backup version based on timestamp
-	backupVersion := fmt.Sprintf("backup-%d", time.Now().Unix())
+	fmt.Fprintf(statusOut, "Found model: %s, version: %s, registry: %s, namespace: %s, manifest path: %s\n", model, version, registry, ref.Namespace, targetVersion.Path)
 
-	// Create the destination directory with format {backup directory}/{model}--{version}--{backup version}/
-	backupPath := filepath.Join(dir, fmt.Sprintf("%s--%s--%s", model, version, backupVersion))
-	if err := os.MkdirAll(backupPath, 0755); err != nil {
-		return fmt.Errorf("failed to create backup directory: %w", err)
+	// A resumed backup continues the newest incomplete backup of the same manifest, keeping its backup version
+	var journal *runJournal
+	if backupResume {
+		found, foundTime, err := findIncompleteBackup(ref, manifestDigest, dir)
+		if err != nil {
+			return err
+		}
+		if found != nil {
+			journal, backupTime = found, foundTime
+			fmt.Fprintf(statusOut, "Resuming incomplete backup from %s (attempt %d, %d of %d blobs verified)\n", backupTime.UTC().Format(time.RFC3339), journal.Attempts+1, journal.verifiedCount(), len(journal.Blobs))
+		}
 	}
 
-	// Create the blobs directory
-	blobsDir := filepath.Join(backupPath, "blobs")
-	if err := os.MkdirAll(blobsDir, 0755); err != nil {
-		return fmt.Errorf("failed to create blobs directory: %w", err)
+	dest, err := resolveBackupDestination(ref, dir, backupTime)
+	if err != nil {
+		return err
+	}
+	if dest.RepoMode {
+		if err := initRepository(dir); err != nil {
+			return err
+		}
 	}
+	backupPath := dest.Path
 
-	// Get the Ollama directory for accessing the actual blobs
-	ollamaDir := utils.GetOllamaDirectory()
-	ollamaBlobsDir := filepath.Join(ollamaDir, "models", "blobs")
+	blobs := utils.ManifestBlobs(manifest)
+	if backupResume && journal == nil {
+		var blobNames []string
+		for _, blob := range blobs {
+			blobNames = append(blobNames, blob.FileName())
+		}
+		journal = newRunJournal("backup", ref.String(), targetVersion.Path, dest.Path, []string{manifestDigest}, blobNames)
+	}
 
-	// Extract and copy the blob files from the manifest
-	if layers, ok := manifest["layers"].([]interface{}); ok {
-		for _, layer := range layers {
-			if layerMap, ok := layer.(map[string]interface{}); ok {
-				var sourcePath string
-				var fileName string
-
-				if from, ok := layerMap["from"].(string); ok {
-					// The 'from' field exists, use it as the full path
-					sourcePath = filepath.Join(ollamaDir, from)
-					fileName = filepath.Base(sourcePath)
-				} else if digest, ok := layerMap["digest"].(string); ok {
-					// The 'from' field doesn't exist, use the digest to construct the file name
-					// Convert digest format from "sha256:123abc..." to "sha256-123abc..."
-					digestName := strings.Replace(digest, ":", "-", 1)
-					fileName = digestName
-					sourcePath = filepath.Join(ollamaBlobsDir, fileName)
-				} else {
-					// Skip this layer if neither from nor digest is present
-					fmt.Printf("Skipping layer: no 'from' or 'digest' field found\n")
-					continue
-				}
-
-				destPath := filepath.Join(blobsDir, fileName)
-
-				// Copy the file
-				if err := copyFile(sourcePath, destPath); err != nil {
-					return fmt.Errorf("failed to copy blob file: %w", err)
-				}
-
-				fmt.Printf("Copied blob: %s\n", fileName)
+	// Make sure the destination has room for the blobs that aren't stored yet before writing anything
+	if backupStream == nil {
+		needed := int64(len(manifestData))
+		for _, blob := range blobs {
+			stored := dest.RepoMode || journal.verified(blob.FileName())
+			if !stored || !repositoryHasBlob(dest.BlobsDir, blob) {
+				needed += blob.Size
 			}
 		}
+		if err := checkFreeSpace(dir, needed); err != nil {
+			return err
+		}
 	}
 
-	// Create the directory structure for the manifest
-	// Format: {backup directory}/{model}--{version}--{backup version}/library/manifests/{registry}/library/{model}/{model version}
-
-	// Use the registry from validateModelName instead of extracting it from the manifest
-	manifestDir := filepath.Join(backupPath, "library", "manifests", registry, "library", model)
-	if err := os.MkdirAll(manifestDir, 0755); err != nil {
-		return fmt.Errorf("failed to create manifest directory: %w", err)
+	// With --format the archive is written directly from the Ollama blob store in a single pass,
+	// otherwise the files go into a directory whose blobs may be shared with other snapshots
+	var writer backupWriter
+	if backupStream != nil {
+		writer, err = newArchiveBackupWriter(streamArchiveOutput{backupStream}, backupFormat, backupArchiveCompression)
+	} else if backupFormat != "" {
+		var out *fileArchiveOutput
+		if out, err = createArchiveFile(backupPath); err == nil {
+			if writer, err = newArchiveBackupWriter(out, backupFormat, backupArchiveCompression); err != nil {
+				out.Discard()
+			}
+		}
+	} else {
+		writer, err = newDirBackupWriter(backupPath, dest.BlobsDir, dest.RepoMode, journal)
 	}
-
-	// Marshal the manifest to JSON and save it
-	manifestData, err := json.Marshal(manifest)
 	if err != nil {
-		return fmt.Errorf("failed to marshal manifest: %w", err)
+		return err
 	}
 
-	// Save the manifest to {backup directory}/{model}--{version}--{backup version}/library/manifests/{registry}/library/{model}/{model version}
-	manifestOutputPath := filepath.Join(manifestDir, version)
-	if err := os.WriteFile(manifestOutputPath, manifestData, 0644); err != nil {
+	// Record the provenance and content of the backup, followed by the original manifest bytes untouched at
+	// library/manifests/{registry}/{namespace}/{model}/{model version}; both come before the blobs so the
+	// backup can be read sequentially
+	meta := newBackupMetadata(ref, manifestData, blobs, backupTime)
+	metaData, err := encodeBackupMetadata(meta)
+	if err != nil {
+		writer.Abort()
+		return err
+	}
+	if err := writer.WriteFile(backupMetadataFile, metaData); err != nil {
+		writer.Abort()
+		return fmt.Errorf("failed to write backup metadata: %w", err)
+	}
+	if err := writer.WriteFile(meta.ManifestPath, manifestData); err != nil {
+		writer.Abort()
 		return fmt.Errorf("failed to write manifest file: %w", err)
 	}
 
-	fmt.Printf("Saved manifest for %s:%s from registry %s in backup %s\n", model, version, registry, backupVersion)
+	// Get the Ollama directory for accessing the actual blobs
+	ollamaDir := utils.GetOllamaDirectory()
+	ollamaBlobsDir := filepath.Join(ollamaDir, "models", "blobs")
 
-	// If createZip flag is set, create a zip file of the backup
-	if createZip {
-		zipFilePath := backupPath + ".zip"
-		if err := zipDirectory(backupPath, zipFilePath); err != nil {
-			return fmt.Errorf("failed to create zip file: %w", err)
+	// Extract and copy the config and layer blob files from the manifest, verifying each one against its digest.
+	// A manifest may list the same blob twice, but it is only copied once.
+	var blobFiles []string
+	var toCopy []utils.BlobRef
+	seen := make(map[string]bool)
+	for _, blob := range blobs {
+		fileName := blob.FileName()
+		if !seen[fileName] {
+			seen[fileName] = true
+			blobFiles = append(blobFiles, fileName)
+			toCopy = append(toCopy, blob)
 		}
-		fmt.Printf("Backup zipped successfully to '%s'\n", zipFilePath)
+	}
 
-		// Delete the original backup directory after successful zip creation
-		if err := os.RemoveAll(backupPath); err != nil {
-			return fmt.Errorf("failed to delete original backup directory: %w", err)
+	// A snapshot references its blobs before any of them is written, so every blob in the shared blobs directory
+	// belongs to a snapshot, complete or not. Aborting the backup releases them again.
+	if dest.RepoMode {
+		if err := addSnapshotRefs(dir, dest.Name, blobFiles); err != nil {
+			writer.Abort()
+			return err
 		}
-		fmt.Printf("Original backup directory deleted after successful zip creation\n")
 	}
 
-	return nil
-}
+	// Blobs go into separate files of a directory backup and can be copied at once; archives are a single stream
+	jobs := backupJobs
+	if backupStream != nil || backupFormat != "" {
+		jobs = 1
+	}
+
+	var copyBytes int64
+	var copyBlobs int
+	for _, blob := range toCopy {
+		if !writer.HasBlob(blob) {
+			copyBytes += blob.Size
+			copyBlobs++
+		}
+	}
+	transferProgress.begin("backup", ref.String(), copyBytes, copyBlobs)
+
+	failures := make([]error, len(toCopy))
+	err = runParallel(jobs, len(toCopy), func(ctx context.Context, i int) error {
+		blob := toCopy[i]
+		var sourcePath string
+		if blob.From != "" {
+			// The 'from' field exists, use it as the full path
+			sourcePath = filepath.Join(ollamaDir, blob.From)
+		} else {
+			// The 'from' field doesn't exist, use the digest to construct the file name
+			sourcePath = filepath.Join(ollamaBlobsDir, blob.FileName())
+		}
+		fileName := blob.FileName()
+
+		// Blobs are content-addressed, so a repository blob with the right size is shared instead of copied again
+		if writer.HasBlob(blob) {
+			fmt.Fprintf(statusOut, "Reusing blob: %s\n", fileName)
+			return nil
+		}
+
+		// Copy the file, hashing it while it streams
+		if err := writeBlobFrom(ctx, writer, sourcePath, fileName, blob); err != nil {
+			if isVerificationError(err) {
+				failures[i] = err
+				return nil
+			}
+			return fmt.Errorf("failed to copy blob file: %w", err)
+		}
 
-// copyFile copies a file from src to dst
-func copyFile(src, dst string) error {
-	sourceFile, err := os.Open(src)
+		fmt.Fprintf(statusOut, "Copied blob: %s (verified)\n", fileName)
+		return nil
+	})
+	transferProgress.end()
 	if err != nil {
+		writer.Abort()
 		return err
 	}
-	defer sourceFile.Close()
 
-	destFile, err := os.Create(dst)
-	if err != nil {
+	if err := reportBlobFailures(compactErrors(failures)); err != nil {
+		writer.Abort()
 		return err
 	}
-	defer destFile.Close()
 
-	_, err = io.Copy(destFile, sourceFile)
-	if err != nil {
+	if err := writer.Close(); err != nil {
+		writer.Abort()
 		return err
 	}
 
+	fmt.Fprintf(statusOut, "Saved manifest for %s (%s) in backup %s\n", ref, manifestDigest, dest.Version)
+
+	if backupStream == nil && backupFormat != "" {
+		fmt.Fprintf(statusOut, "Backup archived successfully to '%s'\n", backupPath)
+	}
+
 	return nil
 }
 
-// zipDirectory creates a zip file from the given directory
-func zipDirectory(sourceDir, targetFile string) error {
-	// Create the zip file
-	zipFile, err := os.Create(targetFile)
-	if err != nil {
-		return fmt.Errorf("failed to create zip file: %w", err)
-	}
-	defer zipFile.Close()
+// backupDestination is where a backup is written
+type backupDestination struct {
+	Version  string // Backup version 'backup-{unix time}'
+	Name     string // Backup name '{model}--{version}--{backup version}'
+	Path     string // Backup directory or archive, empty when streaming to stdout
+	BlobsDir string // Directory receiving the blobs of a directory backup
+	RepoMode bool   // The backup is a repository snapshot sharing its blobs with other snapshots
+}
 
-	// Create a new zip archive
-	zipWriter := zip.NewWriter(zipFile)
-	defer zipWriter.Close()
+// findIncompleteBackup finds the newest backup of ref in dir that an earlier run left incomplete with a
+// journal, for the same manifest. It returns the journal and the time of that backup, or nil if there is none.
+func findIncompleteBackup(ref utils.ModelRef, manifestDigest, dir string) (*runJournal, time.Time, error) {
+	parent := dir
+	if useRepository || isRepository(dir) {
+		parent = repositorySnapshotsDir(dir)
+	}
+	entries, err := os.ReadDir(parent)
+	if os.IsNotExist(err) {
+		return nil, time.Time{}, nil
+	} else if err != nil {
+		return nil, time.Time{}, fmt.Errorf("failed to read backup directory: %w", err)
+	}
 
-	// Walk through the directory and add files to the zip
-	err = filepath.Walk(sourceDir, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
+	var newest *runJournal
+	var newestTime time.Time
+	for _, entry := range entries {
+		match := backupNamePattern.FindStringSubmatch(entry.Name())
+		if !entry.IsDir() || match == nil || match[1] != backupLabel(ref) || match[2] != escapeBackupNamePart(ref.Version) || match[4] != "" {
+			continue
 		}
-
-		// Create zip header with the relative path from the source directory
-		header, err := zip.FileInfoHeader(info)
+		journal, err := loadRunJournal(filepath.Join(parent, entry.Name()))
 		if err != nil {
-			return err
+			fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+			continue
+		}
+		if journal == nil || journal.Operation != "backup" || len(journal.Manifests) != 1 || journal.Manifests[0] != manifestDigest {
+			continue
 		}
 
-		// Set the name to be relative to the source directory
-		relPath, err := filepath.Rel(sourceDir, path)
+		unix, err := strconv.ParseInt(match[3], 10, 64)
 		if err != nil {
-			return err
+			continue
 		}
-
-		if relPath == "." {
-			return nil // Skip the root directory itself
+		if backupTime := time.Unix(unix, 0); newest == nil || backupTime.After(newestTime) {
+			newest, newestTime = journal, backupTime
 		}
+	}
+	return newest, newestTime, nil
+}
 
-		// Ensure proper path separators for zip
-		header.Name = filepath.ToSlash(relPath)
+// resolveBackupDestination works out where the backup of ref taken at backupTime is written to in dir,
+// according to --format, --repo and --output, without creating anything
+func resolveBackupDestination(ref utils.ModelRef, dir string, backupTime time.Time) (backupDestination, error) {
+	// In repository mode all blobs live once in the shared blobs directory and snapshots only hold manifests
+	repoMode := backupStream == nil && (useRepository || isRepository(dir))
+	if repoMode && backupFormat != "" {
+		return backupDestination{}, fmt.Errorf("--format %s cannot be used with a backup repository", backupFormat)
+	}
 
-		// Set appropriate flags for directories
-		if info.IsDir() {
-			header.Name += "/" // Add trailing slash for directories
-			header.Method = zip.Store
-		} else {
-			header.Method = zip.Deflate // Use compression for files
-		}
+	// Generate a backup version based on timestamp
+	dest := backupDestination{Version: fmt.Sprintf("backup-%d", backupTime.Unix()), RepoMode: repoMode}
+
+	// The backup is named {backup directory}/{model}--{version}--{backup version}[.{archive format}]
+	dest.Name = fmt.Sprintf("%s--%s--%s", backupLabel(ref), escapeBackupNamePart(ref.Version), dest.Version)
+	switch {
+	case backupStream != nil:
+	case repoMode:
+		dest.Path = filepath.Join(repositorySnapshotsDir(dir), dest.Name)
+		dest.BlobsDir = repositoryBlobsDir(dir)
+	case backupFormat != "":
+		dest.Path = filepath.Join(dir, dest.Name+"."+backupFormat)
+	default:
+		dest.Path = filepath.Join(dir, dest.Name)
+		dest.BlobsDir = filepath.Join(dest.Path, "blobs")
+	}
+	return dest, nil
+}
 
-		// Create the file entry in the zip
-		writer, err := zipWriter.CreateHeader(header)
-		if err != nil {
-			return err
-		}
+// writeBlobFrom streams the blob file at sourcePath into the backup writer.
+// A blob whose size doesn't match the manifest is rejected before anything is written,
+// since archive formats like tar record the size before the content.
+func writeBlobFrom(ctx context.Context, writer backupWriter, sourcePath, fileName string, blob utils.BlobRef) error {
+	sourceFile, err := os.Open(sourcePath)
+	if err != nil {
+		return err
+	}
+	defer sourceFile.Close()
 
-		// If it's a directory, no need to copy content
-		if info.IsDir() {
-			return nil
-		}
+	info, err := sourceFile.Stat()
+	if err != nil {
+		return err
+	}
+	if blob.Size == 0 {
+		blob.Size = info.Size()
+	} else if info.Size() != blob.Size {
+		return &utils.SizeMismatchError{Blob: fileName, Expected: blob.Size, Actual: info.Size()}
+	}
 
-		// Open and copy the file content
-		file, err := os.Open(path)
+	progress := transferProgress.startBlob(fileName, blob.Size)
+	defer progress.finish()
+	return writer.WriteBlob(fileName, blob, func(offset int64) (io.Reader, error) {
+		return openTransferAt(ctx, sourceFile, offset, progress)
+	})
+}
+
+// isVerificationError reports whether err is a blob digest or size mismatch
+func isVerificationError(err error) bool {
+	var digestErr *utils.DigestMismatchError
+	var sizeErr *utils.SizeMismatchError
+	return errors.As(err, &digestErr) || errors.As(err, &sizeErr)
+}
+
+// compactErrors returns the non-nil errors of errs in order
+func compactErrors(errs []error) []error {
+	var compact []error
+	for _, err := range errs {
 		if err != nil {
-			return err
+			compact = append(compact, err)
 		}
-		defer file.Close()
-
-		_, err = io.Copy(writer, file)
-		return err
-	})
+	}
+	return compact
+}
 
-	if err != nil {
-		return fmt.Errorf("failed to add files to zip: %w", err)
+// reportBlobFailures prints a per-blob report of verification failures and returns an error if there were any
+func reportBlobFailures(failures []error) error {
+	if len(failures) == 0 {
+		return nil
 	}
 
-	fmt.Printf("Created zip file: %s\n", targetFile)
-	return nil
+	fmt.Fprintf(os.Stderr, "Blob verification failed for %d blob(s):\n", len(failures))
+	for _, failure := range failures {
+		fmt.Fprintf(os.Stderr, "  - %v\n", failure)
+	}
+	return fmt.Errorf("%d blob(s) failed verification", len(failures))
 }
@@ -0,0 +1,317 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"backup_ollama/internal/utils"
+)
+
+// Backup formats reported by the catalog
+const (
+	backupFormatDirectory = "directory"
+	backupFormatZip       = "zip"
+	backupFormatTar       = "tar"
+	backupFormatTarGz     = "tar.gz"
+	backupFormatTarZst    = "tar.zst"
+	backupFormatSnapshot  = "snapshot"
+)
+
+// backupNamePattern matches backup names in the format '{model}--{version}--backup-{unix time}[.{archive format}]'
+var backupNamePattern = regexp.MustCompile(`^(.+)--(.+)--backup-(\d+)(\.zip|\.tar|\.tar\.gz|\.tar\.zst)?$`)
+
+// resolveBackupPath returns the path of the backup or snapshot called name in dir. Only backup names are
+// accepted, so the path always lies directly in dir and removing it never touches anything else.
+func resolveBackupPath(dir, name string) (string, error) {
+	if name == "." || name == ".." || strings.ContainsAny(name, `/\`) || !backupNamePattern.MatchString(name) {
+		return "", fmt.Errorf("invalid backup name '%s': expected {model}--{version}--backup-{unix time}[.{archive format}]", name)
+	}
+	resolved := filepath.Join(dir, name)
+	if filepath.Dir(resolved) != filepath.Clean(dir) {
+		return "", fmt.Errorf("invalid backup name '%s': not a backup in %s", name, dir)
+	}
+	return resolved, nil
+}
+
+// backupEntry describes a single backup found in a backup directory
+type backupEntry struct {
+	Name           string          `json:"name"`
+	Path           string          `json:"path"`
+	Format         string          `json:"format"`
+	Model          string          `json:"model"`
+	Ref            utils.ModelRef  `json:"-"`
+	CreatedAt      time.Time       `json:"createdAt"`
+	Size           int64           `json:"size"`
+	Blobs          int             `json:"blobs"`
+	ManifestDigest string          `json:"manifestDigest,omitempty"`
+	Metadata       *backupMetadata `json:"-"`
+}
+
+// backupGroup holds all backups of a single model, newest first
+type backupGroup struct {
+	Model   string        `json:"model"`
+	Backups []backupEntry `json:"backups"`
+}
+
+// scanBackups catalogs every backup in dir, both expanded directories and archives.
+// If dir is a repository, its snapshots are cataloged instead. Entries that are not backups are ignored.
+func scanBackups(dir string) ([]backupEntry, error) {
+	backupsDir := dir
+	format := backupFormatDirectory
+	if isRepository(dir) {
+		backupsDir = repositorySnapshotsDir(dir)
+		format = backupFormatSnapshot
+	}
+
+	entries, err := os.ReadDir(backupsDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read backup directory: %w", err)
+	}
+
+	var backups []backupEntry
+	for _, entry := range entries {
+		entryPath := filepath.Join(backupsDir, entry.Name())
+
+		var backup *backupEntry
+		if entry.IsDir() {
+			if _, err := os.Stat(filepath.Join(entryPath, "library", "manifests")); err != nil {
+				continue
+			}
+			backup, err = catalogDirectoryBackup(entryPath, format)
+		} else if format, ok := archiveFormatOf(entry.Name()); ok {
+			backup, err = catalogArchiveBackup(entryPath, format)
+		} else {
+			continue
+		}
+
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Skipping %s: %v\n", entryPath, err)
+			continue
+		}
+		backups = append(backups, *backup)
+	}
+
+	return backups, nil
+}
+
+// catalogDirectoryBackup describes an expanded backup directory or repository snapshot
+func catalogDirectoryBackup(backupPath, format string) (*backupEntry, error) {
+	meta, err := readBackupMetadata(backupPath)
+	if err != nil {
+		return nil, err
+	}
+
+	manifests := make(map[string][]byte)
+	if meta == nil {
+		// Without metadata the manifests are the only source of model names and sizes
+		manifestsDir := filepath.Join(backupPath, "library", "manifests")
+		err := filepath.Walk(manifestsDir, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() {
+				return nil
+			}
+
+			relPath, err := filepath.Rel(manifestsDir, path)
+			if err != nil {
+				return err
+			}
+
+			content, err := os.ReadFile(path)
+			if err != nil {
+				return fmt.Errorf("failed to read manifest %s: %w", path, err)
+			}
+			manifests[filepath.ToSlash(relPath)] = content
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return newBackupEntry(backupPath, format, meta, manifests)
+}
+
+// catalogArchiveBackup describes an archived backup without extracting it.
+// Archives with metadata are only read up to the first blob, since backup.json and the manifest come first.
+func catalogArchiveBackup(archivePath, format string) (*backupEntry, error) {
+	reader, _, err := openBackupArchive(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	var meta *backupMetadata
+	manifests := make(map[string][]byte)
+	for {
+		entry, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !entry.Mode.IsRegular() {
+			continue
+		}
+
+		switch {
+		case entry.Name == backupMetadataFile:
+			content, err := readArchiveEntry(entry)
+			if err != nil {
+				return nil, err
+			}
+			if meta, err = parseBackupMetadata(content); err != nil {
+				return nil, err
+			}
+		case strings.HasPrefix(entry.Name, "library/manifests/"):
+			content, err := readArchiveEntry(entry)
+			if err != nil {
+				return nil, err
+			}
+			manifests[strings.TrimPrefix(entry.Name, "library/manifests/")] = content
+		case strings.HasPrefix(entry.Name, "blobs/") && meta != nil:
+			return newBackupEntry(archivePath, format, meta, manifests)
+		}
+	}
+
+	return newBackupEntry(archivePath, format, meta, manifests)
+}
+
+// newBackupEntry builds a catalog entry from the backup metadata or, for backups without metadata,
+// from the backup name and the manifests found in the backup
+func newBackupEntry(backupPath, format string, meta *backupMetadata, manifests map[string][]byte) (*backupEntry, error) {
+	entry := &backupEntry{
+		Name:     filepath.Base(backupPath),
+		Path:     backupPath,
+		Format:   format,
+		Metadata: meta,
+	}
+
+	if meta != nil {
+		entry.Ref = meta.Ref()
+		entry.CreatedAt = meta.CreatedAt
+		entry.Size = meta.TotalSize()
+		entry.Blobs = len(meta.Blobs)
+		entry.ManifestDigest = meta.ManifestDigest
+		entry.Model = entry.Ref.ShortName()
+		return entry, nil
+	}
+
+	// Take the creation time from the backup name
+	match := backupNamePattern.FindStringSubmatch(entry.Name)
+	if match == nil {
+		return nil, fmt.Errorf("not a backup name: %s", entry.Name)
+	}
+	unixTime, err := strconv.ParseInt(match[3], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid backup time in %s: %w", entry.Name, err)
+	}
+	entry.CreatedAt = time.Unix(unixTime, 0).UTC()
+
+	if len(manifests) != 1 {
+		return nil, fmt.Errorf("expected exactly one manifest, found %d", len(manifests))
+	}
+	for relPath, content := range manifests {
+		ref, ok := utils.ParseManifestPath(relPath)
+		if !ok {
+			return nil, fmt.Errorf("unexpected manifest path: %s", relPath)
+		}
+
+		var manifest map[string]interface{}
+		if err := json.Unmarshal(content, &manifest); err != nil {
+			return nil, fmt.Errorf("failed to parse manifest %s: %w", relPath, err)
+		}
+
+		entry.Ref = ref
+		entry.Model = ref.ShortName()
+		entry.ManifestDigest = utils.BytesDigest(content)
+		entry.Size = int64(len(content))
+		for _, blob := range utils.ManifestBlobs(manifest) {
+			entry.Size += blob.Size
+			entry.Blobs++
+		}
+	}
+
+	return entry, nil
+}
+
+// groupBackups groups backups by their fully qualified model reference, sorted by model name with the newest
+// backup first. Keying by the reference rather than the displayed name keeps models from different registries
+// and namespaces apart, so they never share a group.
+func groupBackups(backups []backupEntry) []backupGroup {
+	byRef := make(map[string]*backupGroup)
+	var refs []string
+	for _, backup := range backups {
+		ref := backup.Ref.String()
+		group, ok := byRef[ref]
+		if !ok {
+			group = &backupGroup{Model: backup.Model}
+			byRef[ref] = group
+			refs = append(refs, ref)
+		}
+		group.Backups = append(group.Backups, backup)
+	}
+	sort.Slice(refs, func(i, j int) bool {
+		if byRef[refs[i]].Model != byRef[refs[j]].Model {
+			return byRef[refs[i]].Model < byRef[refs[j]].Model
+		}
+		return refs[i] < refs[j]
+	})
+
+	groups := make([]backupGroup, 0, len(refs))
+	for _, ref := range refs {
+		group := byRef[ref]
+		sortBackupsNewestFirst(group.Backups)
+		groups = append(groups, *group)
+	}
+	return groups
+}
+
+// sortBackupsNewestFirst sorts backups by creation time, newest first
+func sortBackupsNewestFirst(backups []backupEntry) {
+	sort.SliceStable(backups, func(i, j int) bool {
+		return backups[i].CreatedAt.After(backups[j].CreatedAt)
+	})
+}
+
+// parseBackupTime parses a point in time given on the command line as unix time, RFC 3339,
+// 'YYYY-MM-DD HH:MM:SS', 'YYYY-MM-DD HH:MM' or 'YYYY-MM-DD' (the last three in local time).
+// It also returns the precision of the given value, e.g. 24 hours for a plain date.
+func parseBackupTime(value string) (time.Time, time.Duration, error) {
+	value = strings.TrimPrefix(strings.TrimSpace(value), "backup-")
+
+	if unixTime, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(unixTime, 0), time.Second, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, time.Second, nil
+	}
+
+	layouts := []struct {
+		layout    string
+		precision time.Duration
+	}{
+		{"2006-01-02T15:04:05", time.Second},
+		{"2006-01-02 15:04:05", time.Second},
+		{"2006-01-02T15:04", time.Minute},
+		{"2006-01-02 15:04", time.Minute},
+		{"2006-01-02", 24 * time.Hour},
+	}
+	for _, l := range layouts {
+		if t, err := time.ParseInLocation(l.layout, value, time.Local); err == nil {
+			return t, l.precision, nil
+		}
+	}
+
+	return time.Time{}, 0, fmt.Errorf("invalid time '%s': expected unix time, RFC 3339, 'YYYY-MM-DD' or 'YYYY-MM-DD HH:MM[:SS]'", value)
+}
@@ -0,0 +1,145 @@
+package cmd
+
+import (
+	"archive/zip"
+	"compress/flate"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// zstdMethod is the zip compression method for Zstandard compressed entries
+const zstdMethod = zstd.ZipMethodWinZip
+
+// Default levels used when --compression names a method without a level
+const (
+	defaultDeflateLevel = 6
+	defaultZstdLevel    = 3
+)
+
+// incompressibleMediaTypes lists the manifest media types of blobs that are stored without compression.
+// Model weights and projectors are quantized tensors that barely compress, so compressing them only burns CPU.
+var incompressibleMediaTypes = map[string]bool{
+	"application/vnd.ollama.image.model":     true,
+	"application/vnd.ollama.image.projector": true,
+	"application/vnd.ollama.image.adapter":   true,
+}
+
+func init() {
+	// Let every zip reader in the tool open Zstandard compressed entries
+	zip.RegisterDecompressor(zstdMethod, zstd.ZipDecompressor())
+}
+
+// compression describes how compressible archive entries such as templates, parameters,
+// licenses, system prompts, manifests and backup.json are compressed
+type compression struct {
+	Method uint16 // zip.Store, zip.Deflate or zstdMethod
+	Level  int
+}
+
+// parseCompression parses a --compression value in the format 'store', 'deflate[:1-9]' or 'zstd[:1-22]'
+func parseCompression(value string) (compression, error) {
+	name, levelValue, hasLevel := strings.Cut(strings.ToLower(strings.TrimSpace(value)), ":")
+
+	var c compression
+	var minLevel, maxLevel int
+	switch name {
+	case "store":
+		if hasLevel {
+			return compression{}, fmt.Errorf("compression 'store' doesn't take a level")
+		}
+		return compression{Method: zip.Store}, nil
+	case "deflate":
+		c = compression{Method: zip.Deflate, Level: defaultDeflateLevel}
+		minLevel, maxLevel = flate.BestSpeed, flate.BestCompression
+	case "zstd":
+		c = compression{Method: zstdMethod, Level: defaultZstdLevel}
+		minLevel, maxLevel = 1, 22
+	default:
+		return compression{}, fmt.Errorf("invalid compression '%s': expected store, deflate[:1-9] or zstd[:1-22]", value)
+	}
+
+	if hasLevel {
+		level, err := strconv.Atoi(levelValue)
+		if err != nil || level < minLevel || level > maxLevel {
+			return compression{}, fmt.Errorf("invalid %s compression level '%s': expected %d-%d", name, levelValue, minLevel, maxLevel)
+		}
+		c.Level = level
+	}
+	return c, nil
+}
+
+// String returns the compression in the format accepted by parseCompression
+func (c compression) String() string {
+	switch c.Method {
+	case zip.Store:
+		return "store"
+	case zstdMethod:
+		return fmt.Sprintf("zstd:%d", c.Level)
+	default:
+		return fmt.Sprintf("deflate:%d", c.Level)
+	}
+}
+
+// methodFor returns the zip method for an entry with the given manifest media type.
+// Files that are not blobs, such as manifests and backup.json, have no media type and are always compressible.
+func (c compression) methodFor(mediaType string) uint16 {
+	if incompressibleMediaTypes[mediaType] {
+		return zip.Store
+	}
+	return c.Method
+}
+
+// register installs the compressor for the configured level on a zip writer
+func (c compression) register(writer *zip.Writer) {
+	switch c.Method {
+	case zip.Deflate:
+		writer.RegisterCompressor(zip.Deflate, func(out io.Writer) (io.WriteCloser, error) {
+			return flate.NewWriter(out, c.Level)
+		})
+	case zstdMethod:
+		writer.RegisterCompressor(zstdMethod, zstd.ZipCompressor(zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(c.Level))))
+	}
+}
+
+// archiveCompression returns the compression for a backup in the given format from the --compression value.
+// Zip archives compress entry by entry with any method. tar.gz and tar.zst compress the whole stream, so only
+// the level of their own method can be chosen; other formats don't compress at all.
+func archiveCompression(format, value string, explicit bool) (compression, error) {
+	var c compression
+	if explicit || format == backupFormatZip {
+		var err error
+		if c, err = parseCompression(value); err != nil {
+			return compression{}, err
+		}
+	}
+
+	switch format {
+	case backupFormatZip:
+		return c, nil
+	case backupFormatTarGz:
+		if !explicit {
+			return compression{Method: zip.Deflate, Level: defaultDeflateLevel}, nil
+		}
+		if c.Method != zip.Deflate {
+			return compression{}, fmt.Errorf("tar.gz backups only support deflate compression levels, not '%s'", value)
+		}
+		return c, nil
+	case backupFormatTarZst:
+		if !explicit {
+			return compression{Method: zstdMethod, Level: defaultZstdLevel}, nil
+		}
+		if c.Method != zstdMethod {
+			return compression{}, fmt.Errorf("tar.zst backups only support zstd compression levels, not '%s'", value)
+		}
+		return c, nil
+	}
+
+	if explicit {
+		return compression{}, fmt.Errorf("--compression doesn't apply to %s backups", format)
+	}
+	return compression{Method: zip.Store}, nil
+}
@@ -0,0 +1,172 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// runJournalFile records the state of a resumable backup or restore in the directory it writes to: the
+// incomplete backup directory or snapshot, or the staging directory of a restore. It is removed once the
+// run completes, so a journal always marks a run that can be continued with --resume.
+const runJournalFile = "journal.json"
+
+// Run states recorded in a journal
+const (
+	journalRunning = "running"
+	journalFailed  = "failed"
+)
+
+// Blob states recorded in a journal
+const (
+	journalBlobPending  = "pending"
+	journalBlobCopying  = "copying"  // A partial copy may exist and can be continued from its end
+	journalBlobVerified = "verified" // Complete and verified against its digest, kept by a resumed run
+)
+
+// runJournal is the content of a journal file
+type runJournal struct {
+	Operation   string            `json:"operation"` // "backup" or "restore"
+	Model       string            `json:"model"`
+	Source      string            `json:"source"`
+	Destination string            `json:"destination"`
+	Manifests   []string          `json:"manifests"` // Sorted digests of the manifests written, a resumed run must match them
+	Status      string            `json:"status"`    // running or failed; a run killed outright stays running
+	Attempts    int               `json:"attempts"`
+	StartedAt   time.Time         `json:"startedAt"`
+	UpdatedAt   time.Time         `json:"updatedAt"`
+	Blobs       map[string]string `json:"blobs"` // State of every blob, keyed by blob file name
+
+	mu   sync.Mutex // Guards Blobs and saving, as blobs are copied by several jobs at once
+	path string
+}
+
+// newRunJournal creates the journal of a new run copying the given blob file names
+func newRunJournal(operation, model, source, destination string, manifests []string, blobs []string) *runJournal {
+	j := &runJournal{
+		Operation:   operation,
+		Model:       model,
+		Source:      source,
+		Destination: destination,
+		Manifests:   append([]string(nil), manifests...),
+		StartedAt:   time.Now().UTC(),
+		Blobs:       make(map[string]string),
+	}
+	sort.Strings(j.Manifests)
+	for _, blob := range blobs {
+		j.Blobs[blob] = journalBlobPending
+	}
+	return j
+}
+
+// loadRunJournal reads the journal in dir, returning nil if there is none
+func loadRunJournal(dir string) (*runJournal, error) {
+	path := filepath.Join(dir, runJournalFile)
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read journal: %w", err)
+	}
+
+	var j runJournal
+	if err := json.Unmarshal(data, &j); err != nil {
+		return nil, fmt.Errorf("failed to parse journal %s: %w", path, err)
+	}
+	if j.Blobs == nil {
+		j.Blobs = make(map[string]string)
+	}
+	j.path = path
+	return &j, nil
+}
+
+// matches reports whether an earlier run's journal describes the same run as j, so its state can be reused
+func (j *runJournal) matches(other *runJournal) bool {
+	if other.Operation != j.Operation || other.Source != j.Source || other.Destination != j.Destination || len(other.Manifests) != len(j.Manifests) {
+		return false
+	}
+	for i := range j.Manifests {
+		if other.Manifests[i] != j.Manifests[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// start records a new attempt of the run in dir
+func (j *runJournal) start(dir string) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.path = filepath.Join(dir, runJournalFile)
+	j.Attempts++
+	j.Status = journalRunning
+	return j.saveLocked()
+}
+
+// verified reports whether an earlier attempt copied and verified the blob
+func (j *runJournal) verified(fileName string) bool {
+	if j == nil {
+		return false
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.Blobs[fileName] == journalBlobVerified
+}
+
+// setBlob records the state of a blob
+func (j *runJournal) setBlob(fileName, state string) error {
+	if j == nil {
+		return nil
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.Blobs[fileName] = state
+	return j.saveLocked()
+}
+
+// fail records that the attempt failed and its state was kept for a resumed run
+func (j *runJournal) fail() {
+	if j == nil {
+		return
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.Status = journalFailed
+	j.saveLocked()
+}
+
+// verifiedCount returns the number of blobs copied and verified so far
+func (j *runJournal) verifiedCount() int {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	count := 0
+	for _, state := range j.Blobs {
+		if state == journalBlobVerified {
+			count++
+		}
+	}
+	return count
+}
+
+// remove deletes the journal once the run has completed
+func (j *runJournal) remove() {
+	if j != nil && j.path != "" {
+		os.Remove(j.path)
+	}
+}
+
+func (j *runJournal) saveLocked() error {
+	j.UpdatedAt = time.Now().UTC()
+	data, err := json.MarshalIndent(j, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal journal: %w", err)
+	}
+	if err := writeFileAtomic(j.path, data); err != nil {
+		return fmt.Errorf("failed to write journal: %w", err)
+	}
+	return nil
+}
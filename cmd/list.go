@@ -22,7 +22,7 @@ var listCmd = &cobra.Command{
 	Use:   "list",
 	Short: "List all available Ollama models",
 	Long: `This command lists all models available in the Ollama directory 
-(~/.ollama/models/manifests/{registry}/library/{model}/{version}).
+(~/.ollama/models/manifests/{registry}/{namespace}/{model}/{version}).
 
 It provides information about registries, models, versions, and optionally
 detailed information from the version JSON files.`,
@@ -96,10 +96,16 @@ func outputText(modelList *utils.OllamaModelList, details bool, totalRegs, total
 		fmt.Fprintf(w, "  %-30s\t%-15s\t%-20s\n", "-----", "--------", "----")
 
 		for _, model := range registry.Models {
+			// Models outside the official library are shown with their namespace
+			modelName := model.Name
+			if model.Namespace != utils.DefaultNamespace {
+				modelName = model.Namespace + "/" + model.Name
+			}
+
 			// Print the first version with the model name
 			firstVersion := model.Versions[0]
 			fmt.Fprintf(w, "  %-30s\t%-15s\t%-20s\n",
-				model.Name,
+				modelName,
 				firstVersion.Name,
 				formatBytes(firstVersion.TotalSize))
 
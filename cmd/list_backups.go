@@ -0,0 +1,86 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var listBackupsDir string
+var listBackupsOutputFormat string
+
+// listBackupsCmd represents the list-backups command
+var listBackupsCmd = &cobra.Command{
+	Use:   "list-backups",
+	Short: "List the backups in a backup directory",
+	Long: `This command catalogs every backup in a backup directory, both expanded
+backup folders and archives (zip, tar, tar.gz, tar.zst), without extracting anything.
+
+Backups are grouped by model with the newest backup first. Model names,
+versions, creation times and sizes are taken from each backup's backup.json,
+or from the backup name and its manifests for older backups.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := listBackups(listBackupsDir, listBackupsOutputFormat); err != nil {
+			fmt.Fprintf(os.Stderr, "Error listing backups: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(listBackupsCmd)
+	listBackupsCmd.Flags().StringVarP(&listBackupsDir, "backup-dir", "d", "./backup", "Directory containing the backups")
+	listBackupsCmd.Flags().StringVarP(&listBackupsOutputFormat, "output", "o", "text", "Output format (text, json)")
+}
+
+// listBackups catalogs and displays the backups in dir
+func listBackups(dir string, format string) error {
+	backups, err := scanBackups(dir)
+	if err != nil {
+		return err
+	}
+	groups := groupBackups(backups)
+
+	switch strings.ToLower(format) {
+	case "json":
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(groups); err != nil {
+			return fmt.Errorf("failed to encode JSON: %w", err)
+		}
+		return nil
+	case "text":
+		fallthrough
+	default:
+		return outputBackupsText(dir, groups, len(backups))
+	}
+}
+
+// outputBackupsText formats the backup catalog as text with tables
+func outputBackupsText(dir string, groups []backupGroup, total int) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintf(w, "Found %d backups of %d models in %s\n\n", total, len(groups), dir)
+
+	for _, group := range groups {
+		fmt.Fprintf(w, "Model: %s\n", group.Model)
+		fmt.Fprintf(w, "  %s\t%s\t%s\t%s\n", "BACKUP", "CREATED", "SIZE", "FORMAT")
+		fmt.Fprintf(w, "  %s\t%s\t%s\t%s\n", "------", "-------", "----", "------")
+		for _, backup := range group.Backups {
+			fmt.Fprintf(w, "  %s\t%s\t%s\t%s\n",
+				backup.Name,
+				backup.CreatedAt.Local().Format(time.RFC3339),
+				formatBytes(backup.Size),
+				backup.Format)
+		}
+		fmt.Fprintf(w, "\n")
+	}
+
+	return w.Flush()
+}
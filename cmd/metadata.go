@@ -0,0 +1,123 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"backup_ollama/internal/utils"
+)
+
+// backupMetadataFile is the name of the metadata file stored at the root of every backup
+const backupMetadataFile = "backup.json"
+
+// backupMetadataVersion is the version of the backup.json format
+const backupMetadataVersion = 1
+
+// backupMetadata describes the provenance and content of a single backup
+type backupMetadata struct {
+	FormatVersion  int          `json:"formatVersion"`
+	Name           string       `json:"name"` // Fully qualified name '{registry}/{namespace}/{model}:{version}'
+	Registry       string       `json:"registry"`
+	Namespace      string       `json:"namespace"`
+	Model          string       `json:"model"`
+	Tag            string       `json:"tag"`
+	ManifestPath   string       `json:"manifestPath"` // Path of the manifest inside the backup, using '/' as separator
+	ManifestDigest string       `json:"manifestDigest"`
+	ManifestSize   int64        `json:"manifestSize"`
+	Blobs          []backupBlob `json:"blobs"`
+	CreatedAt      time.Time    `json:"createdAt"`
+	Hostname       string       `json:"hostname"`
+	ToolVersion    string       `json:"toolVersion"`
+	OllamaDir      string       `json:"ollamaDir"`
+}
+
+// backupBlob describes a single blob recorded in the backup metadata
+type backupBlob struct {
+	Digest    string `json:"digest"`
+	MediaType string `json:"mediaType,omitempty"`
+	Size      int64  `json:"size"`
+}
+
+// newBackupMetadata creates the metadata for a backup of ref with the given manifest content and blobs
+func newBackupMetadata(ref utils.ModelRef, manifestData []byte, blobs []utils.BlobRef, createdAt time.Time) *backupMetadata {
+	hostname, _ := os.Hostname()
+
+	meta := &backupMetadata{
+		FormatVersion:  backupMetadataVersion,
+		Name:           ref.String(),
+		Registry:       ref.Registry,
+		Namespace:      ref.Namespace,
+		Model:          ref.Model,
+		Tag:            ref.Version,
+		ManifestPath:   "library/manifests/" + ref.ManifestPath(),
+		ManifestDigest: utils.BytesDigest(manifestData),
+		ManifestSize:   int64(len(manifestData)),
+		Blobs:          make([]backupBlob, 0, len(blobs)),
+		CreatedAt:      createdAt.UTC(),
+		Hostname:       hostname,
+		ToolVersion:    Version,
+		OllamaDir:      utils.GetOllamaDirectory(),
+	}
+
+	// A manifest may list the same blob twice, but the backup stores it only once
+	seen := make(map[string]bool)
+	for _, blob := range blobs {
+		if fileName := blob.FileName(); !seen[fileName] {
+			seen[fileName] = true
+			meta.Blobs = append(meta.Blobs, backupBlob{Digest: blob.Digest, MediaType: blob.MediaType, Size: blob.Size})
+		}
+	}
+	return meta
+}
+
+// Ref returns the model reference the backup was taken from
+func (m *backupMetadata) Ref() utils.ModelRef {
+	return utils.ModelRef{Registry: m.Registry, Namespace: m.Namespace, Model: m.Model, Version: m.Tag}
+}
+
+// TotalSize returns the size of the manifest and all blobs recorded in the metadata.
+// Backups written by earlier versions may record a blob twice; it is only counted once.
+func (m *backupMetadata) TotalSize() int64 {
+	total := m.ManifestSize
+	seen := make(map[string]bool)
+	for _, blob := range m.Blobs {
+		if !seen[blob.Digest] {
+			seen[blob.Digest] = true
+			total += blob.Size
+		}
+	}
+	return total
+}
+
+// encodeBackupMetadata returns the content of the backup.json file for meta
+func encodeBackupMetadata(meta *backupMetadata) ([]byte, error) {
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal backup metadata: %w", err)
+	}
+	return data, nil
+}
+
+// readBackupMetadata reads backup.json from a backup directory.
+// Backups created before backup.json was introduced have no metadata, in which case nil is returned without an error.
+func readBackupMetadata(backupPath string) (*backupMetadata, error) {
+	content, err := os.ReadFile(filepath.Join(backupPath, backupMetadataFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read backup metadata: %w", err)
+	}
+	return parseBackupMetadata(content)
+}
+
+// parseBackupMetadata parses the content of a backup.json file
+func parseBackupMetadata(content []byte) (*backupMetadata, error) {
+	var meta backupMetadata
+	if err := json.Unmarshal(content, &meta); err != nil {
+		return nil, fmt.Errorf("failed to parse backup metadata: %w", err)
+	}
+	return &meta, nil
+}
@@ -0,0 +1,224 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"backup_ollama/internal/utils"
+)
+
+// transferPlan describes what a backup or restore would do, as printed by --dry-run
+type transferPlan struct {
+	Operation     string        `json:"operation"` // "backup" or "restore"
+	Model         string        `json:"model"`
+	Source        string        `json:"source"`
+	Destination   string        `json:"destination"`
+	Blobs         []plannedFile `json:"blobs"`
+	Manifests     []plannedFile `json:"manifests"`
+	TransferBytes int64         `json:"transferBytes"`            // Bytes of the blobs and manifests that would be written
+	FreeBytes     *uint64       `json:"freeBytes,omitempty"`      // Free space on the destination, if known
+	FreeSpaceErr  string        `json:"freeSpaceError,omitempty"` // Why the free space is unknown
+}
+
+// plannedFile is a single blob or manifest in a transferPlan
+type plannedFile struct {
+	Name      string `json:"name"` // Blob file name, or model name for manifests
+	Path      string `json:"path"` // Path the file would be written to
+	MediaType string `json:"mediaType,omitempty"`
+	Size      int64  `json:"size"`
+	Action    string `json:"action"` // copy, skip, overwrite or keep
+}
+
+// parseDryRun validates a --dry-run value. The flag defaults to text output when given without a value.
+func parseDryRun(value string) (string, error) {
+	switch strings.ToLower(value) {
+	case "", "text":
+		return "text", nil
+	case "json":
+		return "json", nil
+	}
+	return "", fmt.Errorf("invalid --dry-run format '%s': expected text or json", value)
+}
+
+// add records a file in the plan and counts the bytes it would write
+func (p *transferPlan) add(files *[]plannedFile, file plannedFile) {
+	*files = append(*files, file)
+	if file.Action == actionCopy || file.Action == actionOverwrite {
+		p.TransferBytes += file.Size
+	}
+}
+
+// setFreeSpace records the free space on the file system a plan writes to
+func (p *transferPlan) setFreeSpace(path string) {
+	free, err := utils.DiskFree(path)
+	if err != nil {
+		p.FreeSpaceErr = err.Error()
+		return
+	}
+	p.FreeBytes = &free
+}
+
+// checkFreeSpace fails if the file system holding path has less than needed bytes available, so a backup
+// or restore is refused before it writes anything rather than failing halfway through.
+// If the free space can't be determined, a warning is printed and the check passes.
+func checkFreeSpace(path string, needed int64) error {
+	free, err := utils.DiskFree(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: could not check the free space on %s: %v\n", path, err)
+		return nil
+	}
+	if needed > 0 && uint64(needed) > free {
+		return fmt.Errorf("not enough free space on %s: %s needed, %s available", path, formatBytes(needed), formatBytes(int64(free)))
+	}
+	return nil
+}
+
+// backupTransferPlan works out what backing up modelName to dir would write, without writing anything
+func backupTransferPlan(modelName, dir string, backupTime time.Time) (*transferPlan, error) {
+	ref, targetVersion, err := validateModelName(modelName)
+	if err != nil {
+		return nil, err
+	}
+	dest, err := resolveBackupDestination(ref, dir, backupTime)
+	if err != nil {
+		return nil, err
+	}
+
+	manifestData, err := os.ReadFile(targetVersion.Path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+	var manifest map[string]interface{}
+	if err := json.Unmarshal(manifestData, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest: %w", err)
+	}
+
+	plan := &transferPlan{
+		Operation:   "backup",
+		Model:       ref.String(),
+		Source:      targetVersion.Path,
+		Destination: dest.Path,
+		Blobs:       []plannedFile{},
+	}
+	if backupStream != nil {
+		plan.Destination = "stdout"
+	}
+
+	for _, blob := range utils.ManifestBlobs(manifest) {
+		fileName := blob.FileName()
+		file := plannedFile{Name: fileName, MediaType: blob.MediaType, Size: blob.Size, Action: actionCopy}
+		if dest.BlobsDir != "" {
+			file.Path = filepath.Join(dest.BlobsDir, fileName)
+			// A repository already holding the blob shares it instead of copying it again
+			if dest.RepoMode && repositoryHasBlob(dest.BlobsDir, blob) {
+				file.Action = actionSkip
+			}
+		}
+		plan.add(&plan.Blobs, file)
+	}
+
+	manifestPath := "library/manifests/" + ref.ManifestPath()
+	if backupFormat == "" && dest.Path != "" {
+		manifestPath = filepath.Join(dest.Path, filepath.FromSlash(manifestPath))
+	}
+	plan.add(&plan.Manifests, plannedFile{Name: ref.String(), Path: manifestPath, Size: int64(len(manifestData)), Action: actionCopy})
+
+	if backupStream == nil {
+		plan.setFreeSpace(dir)
+	}
+	return plan, nil
+}
+
+// restoreTransferPlan describes a restore plan of the backup source into ollamaDir
+func restoreTransferPlan(source, ollamaDir string, plan *restorePlan) *transferPlan {
+	transfer := &transferPlan{
+		Operation:   "restore",
+		Model:       plan.modelNames(),
+		Source:      source,
+		Destination: ollamaDir,
+		Blobs:       []plannedFile{},
+	}
+
+	blobs := make(map[string]utils.BlobRef)
+	for fileName, blob := range plan.Installed {
+		blobs[fileName] = blob
+	}
+	for fileName, blob := range plan.Blobs {
+		blobs[fileName] = blob
+	}
+	for _, fileName := range sortedBlobNames(blobs) {
+		blob := blobs[fileName]
+		path := filepath.Join(plan.BlobsDir, fileName)
+		action := actionCopy
+		if _, ok := plan.Installed[fileName]; ok {
+			action = actionSkip
+		} else if plan.Replace[path] {
+			action = actionOverwrite
+		}
+		transfer.add(&transfer.Blobs, plannedFile{Name: fileName, Path: path, MediaType: blob.MediaType, Size: blob.Size, Action: action})
+	}
+
+	for _, manifest := range plan.Manifests {
+		path := filepath.Join(plan.ManifestsDir, filepath.FromSlash(manifest.Ref.ManifestPath()))
+		transfer.add(&transfer.Manifests, plannedFile{Name: manifest.Ref.String(), Path: path, Size: int64(len(manifest.Data)), Action: manifest.Action})
+	}
+
+	transfer.setFreeSpace(ollamaDir)
+	return transfer
+}
+
+// outputTransferPlans prints dry-run plans as text or as a JSON array
+func outputTransferPlans(w io.Writer, plans []*transferPlan, format string) error {
+	if format == "json" {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(plans); err != nil {
+			return fmt.Errorf("failed to encode JSON: %w", err)
+		}
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for i, plan := range plans {
+		if i > 0 {
+			fmt.Fprintln(tw)
+		}
+		fmt.Fprintf(tw, "Dry run: %s %s\n", plan.Operation, plan.Model)
+		fmt.Fprintf(tw, "  From: %s\n", plan.Source)
+		fmt.Fprintf(tw, "  To:   %s\n\n", plan.Destination)
+
+		fmt.Fprintf(tw, "  %s\t%s\t%s\t%s\n", "ACTION", "BLOB", "MEDIA TYPE", "SIZE")
+		fmt.Fprintf(tw, "  %s\t%s\t%s\t%s\n", "------", "----", "----------", "----")
+		counts := make(map[string]int)
+		for _, blob := range plan.Blobs {
+			counts[blob.Action]++
+			fmt.Fprintf(tw, "  %s\t%s\t%s\t%s\n", blob.Action, truncateString(blob.Name, 24), blob.MediaType, formatBytes(blob.Size))
+		}
+
+		fmt.Fprintf(tw, "\n  %s\t%s\n", "ACTION", "MANIFEST")
+		fmt.Fprintf(tw, "  %s\t%s\n", "------", "--------")
+		for _, manifest := range plan.Manifests {
+			fmt.Fprintf(tw, "  %s\t%s\n", manifest.Action, manifest.Path)
+		}
+
+		fmt.Fprintf(tw, "\n  Blobs: %d to copy, %d to skip, %d to overwrite\n", counts[actionCopy], counts[actionSkip], counts[actionOverwrite])
+		fmt.Fprintf(tw, "  Bytes to write: %s\n", formatBytes(plan.TransferBytes))
+		switch {
+		case plan.FreeBytes != nil:
+			fmt.Fprintf(tw, "  Free space on destination: %s\n", formatBytes(int64(*plan.FreeBytes)))
+			if uint64(plan.TransferBytes) > *plan.FreeBytes {
+				fmt.Fprintln(tw, "  Not enough free space: this would be refused")
+			}
+		case plan.FreeSpaceErr != "":
+			fmt.Fprintf(tw, "  Free space on destination: unknown (%s)\n", plan.FreeSpaceErr)
+		}
+	}
+	fmt.Fprintln(tw, "\nDry run, nothing was written.")
+	return tw.Flush()
+}
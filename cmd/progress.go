@@ -0,0 +1,324 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Values of --progress
+const (
+	progressAuto = "auto" // A bar on a terminal, log lines otherwise
+	progressBar  = "bar"  // A live bar redrawn in place
+	progressLog  = "log"  // A line every progressLogInterval, for log files and CI
+	progressJSON = "json" // One JSON event per line, for wrappers and dashboards
+	progressNone = "none" // No progress output
+)
+
+// How often each progress mode reports
+const (
+	progressBarInterval  = 200 * time.Millisecond
+	progressLogInterval  = 10 * time.Second
+	progressJSONInterval = time.Second
+)
+
+// transferProgress reports the blob copies of the current backup or restore, silent until --progress is set up
+var transferProgress = &progress{mode: progressNone}
+
+// setProgressMode resolves a --progress value. Progress goes to stderr, so it never mixes with an archive
+// streamed to stdout or a JSON dry-run plan.
+func setProgressMode(value string) error {
+	mode := strings.ToLower(value)
+	switch mode {
+	case progressAuto:
+		mode = progressLog
+		if isTerminal(os.Stderr) {
+			mode = progressBar
+		}
+	case progressBar, progressLog, progressJSON, progressNone:
+	default:
+		return fmt.Errorf("invalid --progress '%s': expected auto, bar, log, json or none", value)
+	}
+	transferProgress = &progress{mode: mode, out: os.Stderr}
+	return nil
+}
+
+// isTerminal reports whether f is a terminal rather than a file or pipe
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// progress tracks the bytes copied by one backup or restore and reports them with throughput and ETA.
+// Blobs copied by several jobs at once are tracked separately and add up to the total.
+type progress struct {
+	mode string
+	out  io.Writer
+
+	mu        sync.Mutex
+	operation string // "backup" or "restore"
+	model     string
+	total     int64
+	blobs     int
+	blobsDone int
+	active    []*blobProgress
+	start     time.Time
+	done      atomic.Int64
+	resumed   atomic.Int64 // Bytes copied by an earlier run, counted as done but not in the throughput
+	stop      chan struct{}
+	stopped   chan struct{}
+}
+
+// blobProgress tracks the copy of a single blob
+type blobProgress struct {
+	p    *progress
+	name string
+	size int64
+	done atomic.Int64
+}
+
+// progressEvent is a line of --progress=json output
+type progressEvent struct {
+	Event          string           `json:"event"` // start, blob_start, progress, blob_done or done
+	Time           time.Time        `json:"time"`
+	Operation      string           `json:"operation"`
+	Model          string           `json:"model"`
+	Blob           string           `json:"blob,omitempty"`
+	BlobSize       int64            `json:"blobSize,omitempty"`
+	Bytes          int64            `json:"bytes"`
+	TotalBytes     int64            `json:"totalBytes"`
+	BlobsDone      int              `json:"blobsDone"`
+	Blobs          int              `json:"blobs"`
+	BytesPerSecond float64          `json:"bytesPerSecond"`
+	ETASeconds     *float64         `json:"etaSeconds,omitempty"`
+	Active         []activeBlobJSON `json:"active,omitempty"`
+}
+
+// activeBlobJSON is the state of a blob being copied in a progress event
+type activeBlobJSON struct {
+	Blob  string `json:"blob"`
+	Bytes int64  `json:"bytes"`
+	Size  int64  `json:"size"`
+}
+
+// begin starts reporting a backup or restore of model that copies blobs totalling total bytes
+func (p *progress) begin(operation, model string, total int64, blobs int) {
+	if p.mode == progressNone {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.operation, p.model = operation, model
+	p.total, p.blobs, p.blobsDone = total, blobs, 0
+	p.active = nil
+	p.start = time.Now()
+	p.done.Store(0)
+	p.resumed.Store(0)
+	p.emit("start", nil)
+
+	interval := progressLogInterval
+	switch p.mode {
+	case progressBar:
+		interval = progressBarInterval
+	case progressJSON:
+		interval = progressJSONInterval
+	}
+	p.stop = make(chan struct{})
+	p.stopped = make(chan struct{})
+	go p.tick(interval, p.stop, p.stopped)
+}
+
+// tick reports the progress every interval until stop is closed
+func (p *progress) tick(interval time.Duration, stop, stopped chan struct{}) {
+	defer close(stopped)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			p.mu.Lock()
+			p.report()
+			p.mu.Unlock()
+		case <-stop:
+			return
+		}
+	}
+}
+
+// end stops reporting and prints a summary of what was copied. It is safe to call without begin.
+func (p *progress) end() {
+	if p.mode == progressNone || p.stop == nil {
+		return
+	}
+	close(p.stop)
+	<-p.stopped
+	p.stop = nil
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	done := p.done.Load()
+	elapsed := time.Since(p.start)
+	switch p.mode {
+	case progressJSON:
+		p.emit("done", nil)
+	case progressBar, progressLog:
+		if p.mode == progressBar {
+			fmt.Fprint(p.out, "\r\033[K")
+		}
+		if copied := done - p.resumed.Load(); copied > 0 {
+			fmt.Fprintf(p.out, "Copied %s in %s (%s/s)\n", formatBytes(copied), elapsed.Round(time.Second), formatBytes(int64(p.rate(done, elapsed))))
+		}
+	}
+}
+
+// startBlob starts tracking the copy of a blob. The result may be used even when progress is off.
+func (p *progress) startBlob(name string, size int64) *blobProgress {
+	b := &blobProgress{p: p, name: name, size: size}
+	if p.mode == progressNone {
+		return b
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.active = append(p.active, b)
+	p.emit("blob_start", b)
+	return b
+}
+
+// add counts n more bytes of the blob as copied
+func (b *blobProgress) add(n int) {
+	b.done.Add(int64(n))
+	b.p.done.Add(int64(n))
+}
+
+// resume counts the first n bytes of the blob as copied by an earlier run
+func (b *blobProgress) resume(n int64) {
+	b.done.Add(n)
+	b.p.done.Add(n)
+	b.p.resumed.Add(n)
+}
+
+// finish stops tracking the blob, whether its copy succeeded or not
+func (b *blobProgress) finish() {
+	p := b.p
+	if p.mode == progressNone {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i, active := range p.active {
+		if active == b {
+			p.active = append(p.active[:i], p.active[i+1:]...)
+			break
+		}
+	}
+	p.blobsDone++
+	p.emit("blob_done", b)
+	if p.mode == progressBar {
+		// Clear the bar so the message about the blob starts on an empty line; the next tick redraws it
+		fmt.Fprint(p.out, "\r\033[K")
+	}
+}
+
+// rate returns the average throughput in bytes per second, not counting bytes resumed from an earlier run
+func (p *progress) rate(done int64, elapsed time.Duration) float64 {
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(done-p.resumed.Load()) / elapsed.Seconds()
+}
+
+// eta estimates the time left from the average throughput, or -1 if it can't be estimated yet
+func (p *progress) eta(done int64, rate float64) time.Duration {
+	if rate <= 0 || done > p.total {
+		return -1
+	}
+	return time.Duration(float64(p.total-done) / rate * float64(time.Second))
+}
+
+// report prints the current progress in the configured mode. p.mu must be held.
+func (p *progress) report() {
+	done := p.done.Load()
+	rate := p.rate(done, time.Since(p.start))
+	eta := "unknown"
+	if d := p.eta(done, rate); d >= 0 {
+		eta = d.Round(time.Second).String()
+	}
+	percent := 0.0
+	if p.total > 0 {
+		percent = float64(done) / float64(p.total) * 100
+	}
+
+	// The blob copied the longest is shown, with how far it got
+	current := ""
+	if len(p.active) > 0 {
+		b := p.active[0]
+		current = truncateString(b.name, 20)
+		if b.size > 0 {
+			current += fmt.Sprintf(" %.0f%%", float64(b.done.Load())/float64(b.size)*100)
+		}
+		if len(p.active) > 1 {
+			current += fmt.Sprintf(" (+%d)", len(p.active)-1)
+		}
+	}
+
+	switch p.mode {
+	case progressJSON:
+		p.emit("progress", nil)
+	case progressLog:
+		fmt.Fprintf(p.out, "Progress: %.0f%% (%s of %s, %d/%d blobs), %s/s, ETA %s, copying %s\n",
+			percent, formatBytes(done), formatBytes(p.total), p.blobsDone, p.blobs, formatBytes(int64(rate)), eta, current)
+	case progressBar:
+		const width = 24
+		filled := int(percent / 100 * width)
+		if filled > width {
+			filled = width
+		}
+		bar := strings.Repeat("=", filled) + strings.Repeat(" ", width-filled)
+		fmt.Fprintf(p.out, "\r\033[K[%s] %3.0f%% %s/%s %s/s ETA %s %s",
+			bar, percent, formatBytes(done), formatBytes(p.total), formatBytes(int64(rate)), eta, current)
+	}
+}
+
+// emit writes a JSON event in --progress=json mode; blob is the blob the event is about, if any. p.mu must be held.
+func (p *progress) emit(event string, blob *blobProgress) {
+	if p.mode != progressJSON {
+		return
+	}
+	done := p.done.Load()
+	now := time.Now()
+	e := progressEvent{
+		Event:          event,
+		Time:           now.UTC(),
+		Operation:      p.operation,
+		Model:          p.model,
+		Bytes:          done,
+		TotalBytes:     p.total,
+		BlobsDone:      p.blobsDone,
+		Blobs:          p.blobs,
+		BytesPerSecond: p.rate(done, now.Sub(p.start)),
+	}
+	if blob != nil {
+		e.Blob, e.BlobSize = blob.name, blob.size
+	}
+	if event == "progress" {
+		if eta := p.eta(done, e.BytesPerSecond); eta >= 0 {
+			seconds := eta.Seconds()
+			e.ETASeconds = &seconds
+		}
+		for _, b := range p.active {
+			e.Active = append(e.Active, activeBlobJSON{Blob: b.name, Bytes: b.done.Load(), Size: b.size})
+		}
+	}
+
+	data, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(p.out, "%s\n", data)
+}
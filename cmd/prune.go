@@ -0,0 +1,389 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"backup_ollama/internal/utils"
+
+	"github.com/spf13/cobra"
+)
+
+var pruneDir string
+var pruneKeepLast int
+var pruneKeepDaily int
+var pruneKeepWeekly int
+var pruneKeepMonthly int
+var pruneMaxAge string
+var pruneDryRun string
+
+// What prune does with a backup
+const (
+	pruneKeep   = "keep"
+	pruneRemove = "remove"
+)
+
+// pruneCmd represents the prune command
+var pruneCmd = &cobra.Command{
+	Use:   "prune [model...]",
+	Short: "Remove old backups according to retention rules",
+	Long: `This command removes old backups from a backup directory according to retention
+rules, applied separately to the backups of every model and tag.
+
+A backup is kept if any of --keep-last, --keep-daily, --keep-weekly or
+--keep-monthly selects it, and removed otherwise; without any of them, every
+backup is kept. --max-age removes backups older than the given age even if a
+keep rule selects them. The newest backup of every model is always kept, so a
+model that hasn't changed in a while never loses its last backup.
+
+Models can be limited by name or glob pattern ('[[registry/]namespace/]model[:version]',
+e.g. 'llama3' or 'llama3:*-q4*'). In a repository, only blobs that no remaining
+snapshot references are deleted. With --dry-run nothing is removed.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		dryRunFormat := ""
+		if cmd.Flags().Changed("dry-run") {
+			var err error
+			if dryRunFormat, err = parseDryRun(pruneDryRun); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+		}
+
+		policy, err := newRetentionPolicy(pruneKeepLast, pruneKeepDaily, pruneKeepWeekly, pruneKeepMonthly, pruneMaxAge)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+
+		plan, err := planPrune(pruneDir, args, policy, time.Now())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error planning prune: %v\n", err)
+			os.Exit(1)
+		}
+
+		if dryRunFormat != "" {
+			if err := outputPrunePlan(os.Stdout, plan, dryRunFormat); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			if dryRunFormat == "text" {
+				fmt.Println("\nDry run, nothing was removed.")
+			}
+			return
+		}
+
+		if err := outputPrunePlan(os.Stdout, plan, "text"); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		if err := prune(plan); err != nil {
+			fmt.Fprintf(os.Stderr, "Error pruning backups: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(pruneCmd)
+	pruneCmd.Flags().StringVarP(&pruneDir, "backup-dir", "d", "./backup", "Directory containing the backups")
+	pruneCmd.Flags().IntVar(&pruneKeepLast, "keep-last", 0, "Keep the N newest backups of every model")
+	pruneCmd.Flags().IntVar(&pruneKeepDaily, "keep-daily", 0, "Keep the newest backup of each of the last N days with backups of a model")
+	pruneCmd.Flags().IntVar(&pruneKeepWeekly, "keep-weekly", 0, "Keep the newest backup of each of the last N weeks with backups of a model")
+	pruneCmd.Flags().IntVar(&pruneKeepMonthly, "keep-monthly", 0, "Keep the newest backup of each of the last N months with backups of a model")
+	pruneCmd.Flags().StringVar(&pruneMaxAge, "max-age", "", "Remove backups older than this age, e.g. 90d, 8w or 36h")
+	pruneCmd.Flags().StringVar(&pruneDryRun, "dry-run", "", "Print which backups and blobs would be removed without removing anything, as text or json")
+	pruneCmd.Flags().Lookup("dry-run").NoOptDefVal = "text"
+}
+
+// retentionPolicy decides which backups of a model prune keeps
+type retentionPolicy struct {
+	KeepLast    int
+	KeepDaily   int
+	KeepWeekly  int
+	KeepMonthly int
+	MaxAge      time.Duration // Backups older than this are removed, 0 for no limit
+	maxAgeText  string
+}
+
+// newRetentionPolicy validates the retention rules given on the command line
+func newRetentionPolicy(keepLast, keepDaily, keepWeekly, keepMonthly int, maxAge string) (retentionPolicy, error) {
+	policy := retentionPolicy{KeepLast: keepLast, KeepDaily: keepDaily, KeepWeekly: keepWeekly, KeepMonthly: keepMonthly, maxAgeText: maxAge}
+	if keepLast < 0 || keepDaily < 0 || keepWeekly < 0 || keepMonthly < 0 {
+		return policy, fmt.Errorf("keep rules must not be negative")
+	}
+	if maxAge != "" {
+		age, err := parseAge(maxAge)
+		if err != nil {
+			return policy, err
+		}
+		policy.MaxAge = age
+	}
+	if !policy.hasKeepRules() && policy.MaxAge == 0 {
+		return policy, fmt.Errorf("no retention rules given: use --keep-last, --keep-daily, --keep-weekly, --keep-monthly or --max-age")
+	}
+	return policy, nil
+}
+
+// parseAge parses an age such as '90d', '8w' or '36h'. Days and weeks are added to the units of time.ParseDuration.
+func parseAge(value string) (time.Duration, error) {
+	s := strings.TrimSpace(value)
+	for suffix, unit := range map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour} {
+		if n, ok := strings.CutSuffix(s, suffix); ok {
+			count, err := strconv.ParseFloat(n, 64)
+			if err != nil || !(count > 0) || count*float64(unit) > math.MaxInt64 {
+				break
+			}
+			return time.Duration(count * float64(unit)), nil
+		}
+	}
+	if age, err := time.ParseDuration(s); err == nil && age > 0 {
+		return age, nil
+	}
+	return 0, fmt.Errorf("invalid age '%s': expected a duration such as 90d, 8w or 36h", value)
+}
+
+// hasKeepRules reports whether any keep rule is set
+func (p retentionPolicy) hasKeepRules() bool {
+	return p.KeepLast > 0 || p.KeepDaily > 0 || p.KeepWeekly > 0 || p.KeepMonthly > 0
+}
+
+// String describes the rules, e.g. 'keep-last 3, keep-daily 7, max-age 90d'
+func (p retentionPolicy) String() string {
+	var rules []string
+	for _, rule := range []struct {
+		name  string
+		count int
+	}{{"keep-last", p.KeepLast}, {"keep-daily", p.KeepDaily}, {"keep-weekly", p.KeepWeekly}, {"keep-monthly", p.KeepMonthly}} {
+		if rule.count > 0 {
+			rules = append(rules, fmt.Sprintf("%s %d", rule.name, rule.count))
+		}
+	}
+	if p.MaxAge > 0 {
+		rules = append(rules, "max-age "+p.maxAgeText)
+	}
+	return strings.Join(rules, ", ")
+}
+
+// apply decides for the backups of a single model, sorted newest first, which ones are kept and why
+func (p retentionPolicy) apply(backups []backupEntry, now time.Time) []prunedBackup {
+	reasons := make([][]string, len(backups))
+	for i := 0; i < p.KeepLast && i < len(backups); i++ {
+		reasons[i] = append(reasons[i], "last")
+	}
+
+	// Each periodic rule keeps the newest backup of the N most recent periods that have backups
+	for _, rule := range []struct {
+		name   string
+		count  int
+		period func(t time.Time) string
+	}{
+		{"daily", p.KeepDaily, func(t time.Time) string { return t.Format("2006-01-02") }},
+		{"weekly", p.KeepWeekly, func(t time.Time) string {
+			year, week := t.ISOWeek()
+			return fmt.Sprintf("%d-W%02d", year, week)
+		}},
+		{"monthly", p.KeepMonthly, func(t time.Time) string { return t.Format("2006-01") }},
+	} {
+		seen := make(map[string]bool)
+		for i, backup := range backups {
+			if len(seen) >= rule.count {
+				break
+			}
+			period := rule.period(backup.CreatedAt.Local())
+			if !seen[period] {
+				seen[period] = true
+				reasons[i] = append(reasons[i], rule.name)
+			}
+		}
+	}
+
+	pruned := make([]prunedBackup, 0, len(backups))
+	for i, backup := range backups {
+		entry := prunedBackup{
+			Model:     backup.Model,
+			Name:      backup.Name,
+			Path:      backup.Path,
+			Format:    backup.Format,
+			CreatedAt: backup.CreatedAt,
+			Size:      backup.Size,
+			Action:    pruneKeep,
+			Reasons:   reasons[i],
+		}
+		switch {
+		case i == 0:
+			entry.Reasons = append(entry.Reasons, "newest")
+		case p.MaxAge > 0 && now.Sub(backup.CreatedAt) > p.MaxAge:
+			entry.Action, entry.Reasons = pruneRemove, []string{"older than " + p.maxAgeText}
+		case !p.hasKeepRules():
+			entry.Reasons = []string{"within " + p.maxAgeText}
+		case len(entry.Reasons) == 0:
+			entry.Action = pruneRemove
+		}
+		if entry.Reasons == nil {
+			entry.Reasons = []string{}
+		}
+		pruned = append(pruned, entry)
+	}
+	return pruned
+}
+
+// prunedBackup is a backup and what prune does with it
+type prunedBackup struct {
+	Model     string    `json:"model"`
+	Name      string    `json:"name"`
+	Path      string    `json:"path"`
+	Format    string    `json:"format"`
+	CreatedAt time.Time `json:"createdAt"`
+	Size      int64     `json:"size"`
+	Action    string    `json:"action"`  // keep or remove
+	Reasons   []string  `json:"reasons"` // Rules that keep the backup, or why it is removed
+}
+
+// prunePlan describes what prune removes from a backup directory, as printed by --dry-run
+type prunePlan struct {
+	Dir        string         `json:"dir"`
+	Policy     string         `json:"policy"`
+	Repository bool           `json:"repository"`
+	Backups    []prunedBackup `json:"backups"`
+	FreedBlobs []string       `json:"freedBlobs"` // Repository blobs no remaining snapshot references
+	FreedBytes int64          `json:"freedBytes"` // Disk space the removed backups and blobs take up
+}
+
+// planPrune applies the retention policy to the backups in dir of every model matching one of the patterns,
+// or of every model without patterns
+func planPrune(dir string, patterns []string, policy retentionPolicy, now time.Time) (*prunePlan, error) {
+	backups, err := scanBackups(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &prunePlan{Dir: dir, Policy: policy.String(), Repository: isRepository(dir), Backups: []prunedBackup{}, FreedBlobs: []string{}}
+	for _, group := range groupBackups(backups) {
+		matched := len(patterns) == 0
+		for _, pattern := range patterns {
+			ok, err := utils.MatchGlob(pattern, group.Backups[0].Ref)
+			if err != nil {
+				return nil, err
+			}
+			matched = matched || ok
+		}
+		if matched {
+			plan.Backups = append(plan.Backups, policy.apply(group.Backups, now)...)
+		}
+	}
+
+	if plan.Repository {
+		return plan, plan.addFreedBlobs()
+	}
+
+	// The blobs of standalone backups are removed with them
+	for _, backup := range plan.Backups {
+		if backup.Action != pruneRemove {
+			continue
+		}
+		if info, err := os.Stat(backup.Path); err == nil && !info.IsDir() {
+			plan.FreedBytes += info.Size()
+		} else {
+			plan.FreedBytes += backup.Size
+		}
+	}
+	return plan, nil
+}
+
+// addFreedBlobs works out which repository blobs no snapshot references once the removed snapshots are gone
+func (p *prunePlan) addFreedBlobs() error {
+	refs, err := loadRepositoryRefs(p.Dir)
+	if err != nil {
+		return err
+	}
+	removed := make(map[string]bool)
+	for _, backup := range p.Backups {
+		if backup.Action == pruneRemove {
+			removed[backup.Name] = true
+		}
+	}
+
+	// Like releaseSnapshot, only blobs referenced by a removed snapshot and by no kept one are deleted
+	for blob, snapshots := range refs {
+		released, kept := false, false
+		for _, snapshot := range snapshots {
+			if removed[snapshot] {
+				released = true
+			} else {
+				kept = true
+			}
+		}
+		if released && !kept {
+			p.FreedBlobs = append(p.FreedBlobs, blob)
+			if info, err := os.Stat(filepath.Join(repositoryBlobsDir(p.Dir), blob)); err == nil {
+				p.FreedBytes += info.Size()
+			}
+		}
+	}
+	sort.Strings(p.FreedBlobs)
+	return nil
+}
+
+// outputPrunePlan prints a prune plan as text or JSON
+func outputPrunePlan(w io.Writer, plan *prunePlan, format string) error {
+	if format == "json" {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(plan); err != nil {
+			return fmt.Errorf("failed to encode JSON: %w", err)
+		}
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "Pruning %s (%s)\n\n", plan.Dir, plan.Policy)
+	fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", "MODEL", "BACKUP", "CREATED", "SIZE", "ACTION", "REASON")
+	fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", "-----", "------", "-------", "----", "------", "------")
+	removed := 0
+	for _, backup := range plan.Backups {
+		if backup.Action == pruneRemove {
+			removed++
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			backup.Model,
+			backup.Name,
+			backup.CreatedAt.Local().Format("2006-01-02 15:04:05"),
+			formatBytes(backup.Size),
+			backup.Action,
+			strings.Join(backup.Reasons, ", "),
+		)
+	}
+
+	fmt.Fprintf(tw, "\n%d backup(s) to remove, %d to keep\n", removed, len(plan.Backups)-removed)
+	if plan.Repository {
+		fmt.Fprintf(tw, "%d blob(s) no longer referenced by any remaining snapshot\n", len(plan.FreedBlobs))
+	}
+	fmt.Fprintf(tw, "Space to free: %s\n", formatBytes(plan.FreedBytes))
+	return tw.Flush()
+}
+
+// prune removes the backups the plan doesn't keep. Repository snapshots are removed one at a time,
+// each deleting only the blobs no other snapshot references.
+func prune(plan *prunePlan) error {
+	removed := 0
+	for _, backup := range plan.Backups {
+		if backup.Action != pruneRemove {
+			continue
+		}
+		if err := removeBackup(backup.Name, plan.Dir); err != nil {
+			return fmt.Errorf("%s: %w", backup.Name, err)
+		}
+		removed++
+	}
+	fmt.Printf("Pruned %d backup(s), freed %s\n", removed, formatBytes(plan.FreedBytes))
+	return nil
+}
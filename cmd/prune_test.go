@@ -0,0 +1,235 @@
+package cmd
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// at returns a point in local time, which is where prune draws the day, week and month boundaries
+func at(year int, month time.Month, day, hour, min int) time.Time {
+	return time.Date(year, month, day, hour, min, 0, 0, time.Local)
+}
+
+// backupsAt returns backups of a single model created at the given times, which must be newest first
+func backupsAt(times ...time.Time) []backupEntry {
+	backups := make([]backupEntry, len(times))
+	for i, t := range times {
+		backups[i] = backupEntry{
+			Name:      fmt.Sprintf("phi3--latest--backup-%d", t.Unix()),
+			Model:     "phi3:latest",
+			CreatedAt: t,
+		}
+	}
+	return backups
+}
+
+func TestRetentionPolicyApply(t *testing.T) {
+	now := at(2026, time.March, 10, 12, 0)
+
+	tests := []struct {
+		name        string
+		keepLast    int
+		keepDaily   int
+		keepWeekly  int
+		keepMonthly int
+		maxAge      string
+		backups     []backupEntry
+		actions     []string
+		reasons     [][]string
+	}{
+		{
+			name:     "keep-last",
+			keepLast: 2,
+			backups:  backupsAt(at(2026, time.March, 9, 12, 0), at(2026, time.March, 8, 12, 0), at(2026, time.March, 7, 12, 0), at(2026, time.March, 6, 12, 0)),
+			actions:  []string{pruneKeep, pruneKeep, pruneRemove, pruneRemove},
+			reasons:  [][]string{{"last", "newest"}, {"last"}, {}, {}},
+		},
+		{
+			name:      "keep-daily across midnight",
+			keepDaily: 2,
+			backups: backupsAt(
+				at(2026, time.March, 9, 18, 0),
+				at(2026, time.March, 9, 0, 1),
+				at(2026, time.March, 8, 23, 59),
+				at(2026, time.March, 8, 9, 0),
+				at(2026, time.March, 7, 12, 0),
+			),
+			actions: []string{pruneKeep, pruneRemove, pruneKeep, pruneRemove, pruneRemove},
+			reasons: [][]string{{"daily", "newest"}, {}, {"daily"}, {}, {}},
+		},
+		{
+			// ISO week 1 of 2026 starts on Monday 2025-12-29, so the first three backups span two weeks, not three
+			name:       "keep-weekly across ISO week and year",
+			keepWeekly: 2,
+			backups: backupsAt(
+				at(2026, time.January, 5, 12, 0),
+				at(2026, time.January, 4, 12, 0),
+				at(2025, time.December, 29, 12, 0),
+				at(2025, time.December, 28, 12, 0),
+			),
+			actions: []string{pruneKeep, pruneKeep, pruneRemove, pruneRemove},
+			reasons: [][]string{{"weekly", "newest"}, {"weekly"}, {}, {}},
+		},
+		{
+			name:        "keep-monthly across month ends",
+			keepMonthly: 2,
+			backups: backupsAt(
+				at(2026, time.March, 1, 0, 30),
+				at(2026, time.February, 28, 23, 30),
+				at(2026, time.February, 1, 12, 0),
+				at(2026, time.January, 31, 12, 0),
+			),
+			actions: []string{pruneKeep, pruneKeep, pruneRemove, pruneRemove},
+			reasons: [][]string{{"monthly", "newest"}, {"monthly"}, {}, {}},
+		},
+		{
+			name:        "rules are combined",
+			keepLast:    1,
+			keepDaily:   2,
+			keepMonthly: 2,
+			backups: backupsAt(
+				at(2026, time.March, 9, 18, 0),
+				at(2026, time.March, 9, 9, 0),
+				at(2026, time.March, 8, 12, 0),
+				at(2026, time.March, 1, 12, 0),
+				at(2026, time.February, 20, 12, 0),
+				at(2026, time.February, 10, 12, 0),
+			),
+			actions: []string{pruneKeep, pruneRemove, pruneKeep, pruneRemove, pruneKeep, pruneRemove},
+			reasons: [][]string{{"last", "daily", "monthly", "newest"}, {}, {"daily"}, {}, {"monthly"}, {}},
+		},
+		{
+			name:     "max-age overrides keep rules",
+			keepLast: 3,
+			maxAge:   "2d",
+			backups:  backupsAt(now.Add(-time.Hour), now.Add(-24*time.Hour), now.Add(-72*time.Hour)),
+			actions:  []string{pruneKeep, pruneKeep, pruneRemove},
+			reasons:  [][]string{{"last", "newest"}, {"last"}, {"older than 2d"}},
+		},
+		{
+			name:    "max-age alone keeps younger backups",
+			maxAge:  "36h",
+			backups: backupsAt(now.Add(-time.Hour), now.Add(-24*time.Hour), now.Add(-48*time.Hour)),
+			actions: []string{pruneKeep, pruneKeep, pruneRemove},
+			reasons: [][]string{{"newest"}, {"within 36h"}, {"older than 36h"}},
+		},
+		{
+			name:    "newest is kept even past max-age",
+			maxAge:  "1d",
+			backups: backupsAt(now.Add(-100*24*time.Hour), now.Add(-200*24*time.Hour)),
+			actions: []string{pruneKeep, pruneRemove},
+			reasons: [][]string{{"newest"}, {"older than 1d"}},
+		},
+		{
+			name:       "newest is kept by a rule selecting older backups",
+			keepWeekly: 1,
+			maxAge:     "1w",
+			backups:    backupsAt(now.Add(-30*24*time.Hour), now.Add(-31*24*time.Hour)),
+			actions:    []string{pruneKeep, pruneRemove},
+			reasons:    [][]string{{"weekly", "newest"}, {"older than 1w"}},
+		},
+		{
+			name:     "single backup",
+			keepLast: 1,
+			backups:  backupsAt(now.Add(-time.Hour)),
+			actions:  []string{pruneKeep},
+			reasons:  [][]string{{"last", "newest"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy, err := newRetentionPolicy(tt.keepLast, tt.keepDaily, tt.keepWeekly, tt.keepMonthly, tt.maxAge)
+			if err != nil {
+				t.Fatalf("newRetentionPolicy: %v", err)
+			}
+			pruned := policy.apply(tt.backups, now)
+			if len(pruned) != len(tt.backups) {
+				t.Fatalf("got %d decisions for %d backups", len(pruned), len(tt.backups))
+			}
+
+			var actions []string
+			var reasons [][]string
+			for i, backup := range pruned {
+				if backup.Name != tt.backups[i].Name {
+					t.Errorf("decision %d is for %s, want %s", i, backup.Name, tt.backups[i].Name)
+				}
+				actions = append(actions, backup.Action)
+				reasons = append(reasons, backup.Reasons)
+			}
+			if !reflect.DeepEqual(actions, tt.actions) {
+				t.Errorf("actions = %v, want %v", actions, tt.actions)
+			}
+			if !reflect.DeepEqual(reasons, tt.reasons) {
+				t.Errorf("reasons = %v, want %v", reasons, tt.reasons)
+			}
+		})
+	}
+}
+
+func TestNewRetentionPolicyErrors(t *testing.T) {
+	tests := []struct {
+		name                                         string
+		keepLast, keepDaily, keepWeekly, keepMonthly int
+		maxAge                                       string
+	}{
+		{name: "no rules"},
+		{name: "negative keep-last", keepLast: -1},
+		{name: "negative keep-monthly", keepMonthly: -2, keepDaily: 3},
+		{name: "invalid max-age", keepLast: 1, maxAge: "soon"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := newRetentionPolicy(tt.keepLast, tt.keepDaily, tt.keepWeekly, tt.keepMonthly, tt.maxAge); err == nil {
+				t.Errorf("expected an error")
+			}
+		})
+	}
+}
+
+func TestParseAge(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{value: "90d", want: 90 * 24 * time.Hour},
+		{value: "8w", want: 8 * 7 * 24 * time.Hour},
+		{value: "36h", want: 36 * time.Hour},
+		{value: "1h30m", want: 90 * time.Minute},
+		{value: "1.5d", want: 36 * time.Hour},
+		{value: " 7d ", want: 7 * 24 * time.Hour},
+		{value: "0d", wantErr: true},
+		{value: "xd", wantErr: true},
+		{value: "-1w", wantErr: true},
+		{value: "-5h", wantErr: true},
+		{value: "0", wantErr: true},
+		{value: "d", wantErr: true},
+		{value: "NaNd", wantErr: true},
+		{value: "Infw", wantErr: true},
+		{value: "1e300d", wantErr: true},
+		{value: "90", wantErr: true},
+		{value: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := parseAge(tt.value)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("parseAge(%q) = %v, want an error", tt.value, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseAge(%q): %v", tt.value, err)
+			}
+			if got != tt.want {
+				t.Errorf("parseAge(%q) = %v, want %v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
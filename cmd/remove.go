@@ -0,0 +1,56 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+// removeBackupCmd represents the remove-backup command
+var removeBackupCmd = &cobra.Command{
+	Use:   "remove-backup [backup name]",
+	Short: "Remove a backup or repository snapshot",
+	Long: `This command removes a backup directory or archive from the backup directory.
+
+If the backup directory is a repository, the named snapshot is removed and every
+blob that is no longer referenced by any other snapshot is deleted from the
+shared blobs directory.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		backupName := args[0]
+		backupDir, _ := cmd.Flags().GetString("backup-dir")
+
+		if err := removeBackup(backupName, backupDir); err != nil {
+			fmt.Fprintf(os.Stderr, "Error removing backup: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(removeBackupCmd)
+	removeBackupCmd.Flags().StringP("backup-dir", "d", "./backup", "Directory containing the backup")
+}
+
+// removeBackup removes a standalone backup or a repository snapshot
+func removeBackup(backupName, backupDir string) error {
+	if isRepository(backupDir) {
+		return removeSnapshot(backupDir, backupName)
+	}
+
+	backupPath, err := resolveBackupPath(backupDir, backupName)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(backupPath); err != nil {
+		return fmt.Errorf("backup not found: %w", err)
+	}
+
+	if err := os.RemoveAll(backupPath); err != nil {
+		return fmt.Errorf("failed to remove backup: %w", err)
+	}
+
+	fmt.Printf("Removed backup '%s'\n", backupName)
+	return nil
+}
@@ -0,0 +1,297 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"backup_ollama/internal/utils"
+)
+
+// A backup repository stores every blob once in a shared content-addressed blobs/ directory.
+// Each snapshot only records its manifests, and refs.json tracks which snapshots reference which blob:
+//
+//	{repository}/repository.json
+//	{repository}/refs.json
+//	{repository}/repository.lock
+//	{repository}/blobs/sha256-<hex>
+//	{repository}/snapshots/{model}--{version}--{backup version}/library/manifests/...
+const (
+	repositoryMarkerFile = "repository.json"
+	repositoryRefsFile   = "refs.json"
+	repositoryLockFile   = "repository.lock" // Locked while refs.json is updated or blobs are deleted
+	repositoryVersion    = 1
+)
+
+// repositoryInfo is the content of the repository.json marker file
+type repositoryInfo struct {
+	Version int `json:"version"`
+}
+
+// repositoryRefs maps each blob file name to the sorted names of the snapshots referencing it
+type repositoryRefs map[string][]string
+
+// isRepository reports whether dir is the root of a backup repository
+func isRepository(dir string) bool {
+	_, err := os.Stat(filepath.Join(dir, repositoryMarkerFile))
+	return err == nil
+}
+
+// initRepository creates the repository layout in dir if it doesn't exist yet
+func initRepository(dir string) error {
+	if isRepository(dir) {
+		return nil
+	}
+
+	// Another backup may be creating the repository at the same time; only one of them writes the empty refs
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create repository directory: %w", err)
+	}
+	lock, err := lockRepository(dir)
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+	if isRepository(dir) {
+		return nil
+	}
+
+	for _, subDir := range []string{repositoryBlobsDir(dir), repositorySnapshotsDir(dir)} {
+		if err := os.MkdirAll(subDir, 0755); err != nil {
+			return fmt.Errorf("failed to create repository directory: %w", err)
+		}
+	}
+
+	if err := saveRepositoryRefs(dir, repositoryRefs{}); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(repositoryInfo{Version: repositoryVersion}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal repository info: %w", err)
+	}
+	if err := writeFileAtomic(filepath.Join(dir, repositoryMarkerFile), data); err != nil {
+		return fmt.Errorf("failed to write repository info: %w", err)
+	}
+
+	fmt.Fprintf(statusOut, "Initialized backup repository in '%s'\n", dir)
+	return nil
+}
+
+// repositoryBlobsDir returns the shared blobs directory of a repository
+func repositoryBlobsDir(dir string) string {
+	return filepath.Join(dir, "blobs")
+}
+
+// repositorySnapshotsDir returns the directory holding the snapshots of a repository
+func repositorySnapshotsDir(dir string) string {
+	return filepath.Join(dir, "snapshots")
+}
+
+// backupBlobsDir returns the directory holding the blobs of the backup at backupPath.
+// Standalone backups have their own blobs/ directory, repository snapshots use the shared one.
+func backupBlobsDir(backupPath string) string {
+	repoDir := filepath.Dir(filepath.Dir(backupPath))
+	if filepath.Base(filepath.Dir(backupPath)) == "snapshots" && isRepository(repoDir) {
+		return repositoryBlobsDir(repoDir)
+	}
+	return filepath.Join(backupPath, "blobs")
+}
+
+// loadRepositoryRefs reads the blob reference counts of a repository
+func loadRepositoryRefs(dir string) (repositoryRefs, error) {
+	content, err := os.ReadFile(filepath.Join(dir, repositoryRefsFile))
+	if os.IsNotExist(err) {
+		return repositoryRefs{}, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read repository refs: %w", err)
+	}
+
+	refs := repositoryRefs{}
+	if err := json.Unmarshal(content, &refs); err != nil {
+		return nil, fmt.Errorf("failed to parse repository refs: %w", err)
+	}
+	return refs, nil
+}
+
+// saveRepositoryRefs atomically writes the blob reference counts of a repository
+func saveRepositoryRefs(dir string, refs repositoryRefs) error {
+	data, err := json.MarshalIndent(refs, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal repository refs: %w", err)
+	}
+	if err := writeFileAtomic(filepath.Join(dir, repositoryRefsFile), data); err != nil {
+		return fmt.Errorf("failed to write repository refs: %w", err)
+	}
+	return nil
+}
+
+// lockRepository waits for and takes the exclusive lock of a repository. It is held around every update of
+// refs.json and every blob deletion, so concurrent backups, removals and prunes never lose each other's
+// references or delete a blob another snapshot has just started to reference.
+func lockRepository(dir string) (*utils.FileLock, error) {
+	lock, err := utils.LockFile(filepath.Join(dir, repositoryLockFile))
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock repository: %w", err)
+	}
+	return lock, nil
+}
+
+// addSnapshotRefs records that snapshot references each of the given blobs
+func addSnapshotRefs(dir, snapshot string, blobs []string) error {
+	lock, err := lockRepository(dir)
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	refs, err := loadRepositoryRefs(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, blob := range blobs {
+		refs[blob] = addUnique(refs[blob], snapshot)
+	}
+
+	return saveRepositoryRefs(dir, refs)
+}
+
+// removeSnapshot deletes a snapshot from a repository and removes every blob no other snapshot references
+func removeSnapshot(dir, snapshot string) error {
+	snapshotPath, err := resolveBackupPath(repositorySnapshotsDir(dir), snapshot)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(snapshotPath); err != nil {
+		return fmt.Errorf("snapshot not found: %w", err)
+	}
+
+	freed, err := releaseSnapshot(dir, snapshot)
+	for _, blob := range freed {
+		fmt.Printf("Removed unreferenced blob: %s\n", blob)
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Removed snapshot '%s' (%d blobs freed)\n", snapshot, len(freed))
+	return nil
+}
+
+// releaseSnapshot deletes a snapshot, complete or not, and the blobs no other snapshot references, returning
+// the blobs it deleted. The reference counts are updated before any blob is deleted, so an interrupted removal
+// never leaves a snapshot pointing at a deleted blob.
+func releaseSnapshot(dir, snapshot string) ([]string, error) {
+	snapshotPath, err := resolveBackupPath(repositorySnapshotsDir(dir), snapshot)
+	if err != nil {
+		return nil, err
+	}
+
+	lock, err := lockRepository(dir)
+	if err != nil {
+		return nil, err
+	}
+	defer lock.Unlock()
+
+	refs, err := loadRepositoryRefs(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	// Drop the snapshot from every blob's references and collect blobs that are no longer referenced
+	var unreferenced []string
+	for blob, snapshots := range refs {
+		remaining := removeValue(snapshots, snapshot)
+		if len(remaining) == len(snapshots) {
+			continue
+		}
+		if len(remaining) == 0 {
+			delete(refs, blob)
+			unreferenced = append(unreferenced, blob)
+		} else {
+			refs[blob] = remaining
+		}
+	}
+	sort.Strings(unreferenced)
+
+	// Remove the snapshot first so its manifests never point at deleted blobs
+	if err := os.RemoveAll(snapshotPath); err != nil {
+		return nil, fmt.Errorf("failed to remove snapshot: %w", err)
+	}
+
+	if err := saveRepositoryRefs(dir, refs); err != nil {
+		return nil, err
+	}
+
+	blobsDir := repositoryBlobsDir(dir)
+	var freed []string
+	for _, blob := range unreferenced {
+		if err := os.Remove(filepath.Join(blobsDir, blob)); err != nil && !os.IsNotExist(err) {
+			return freed, fmt.Errorf("failed to remove blob %s: %w", blob, err)
+		}
+		// A partial copy left by an interrupted resumable backup goes with the blob
+		os.Remove(filepath.Join(blobsDir, blob+".partial"))
+		freed = append(freed, blob)
+	}
+	return freed, nil
+}
+
+// writeFileAtomic writes data to a temporary file next to path and renames it into place
+func writeFileAtomic(path string, data []byte) error {
+	tmpFile, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmpFile.Name()
+
+	if _, err := tmpFile.Write(data); err != nil {
+		tmpFile.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := tmpFile.Sync(); err != nil {
+		tmpFile.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := tmpFile.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Chmod(tmpPath, 0644); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
+
+// addUnique adds value to the sorted slice values if it isn't present yet
+func addUnique(values []string, value string) []string {
+	for _, v := range values {
+		if v == value {
+			return values
+		}
+	}
+	values = append(values, value)
+	sort.Strings(values)
+	return values
+}
+
+// removeValue returns values without any occurrence of value
+func removeValue(values []string, value string) []string {
+	result := make([]string, 0, len(values))
+	for _, v := range values {
+		if v != value {
+			result = append(result, v)
+		}
+	}
+	return result
+}
@@ -1,12 +1,16 @@
 package cmd
 
 import (
-	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+	"time"
 
 	"backup_ollama/internal/utils"
 
@@ -14,23 +18,103 @@ import (
 )
 
 var restoreCmd = &cobra.Command{
-	Use:   "restore [model name]",
+	Use:   "restore [model name|backup name]",
 	Short: "Restore a model from backup",
-	Long:  `Restore a model from a specified backup directory.`,
-	Args:  cobra.ExactArgs(1),
+	Long: `Restore a model from a specified backup directory.
+
+The argument is either the name of a backup directory or archive (zip, tar, tar.gz
+or tar.zst, detected from its content) in the backup directory, or a model name ('[[registry/]namespace/]model[:version]'). For a model
+name the newest matching backup is restored, unless --at or --before selects an
+older one. If the version is omitted and backups of several versions exist, the
+available versions are listed and nothing is restored.
+
+With '-' as the argument, a tar, tar.gz or tar.zst backup archive is read from stdin
+and restored while it streams, e.g. from 'backup_ollama backup llama3 -o -'.`,
+	Args: cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
 		modelName := args[0]
 		backupDir, _ := cmd.Flags().GetString("backup-dir")
 		ollamaDir, _ := cmd.Flags().GetString("ollama-dir")
 		overwrite, _ := cmd.Flags().GetBool("overwrite")
+		onConflict, _ := cmd.Flags().GetString("on-conflict")
+		at, _ := cmd.Flags().GetString("at")
+		before, _ := cmd.Flags().GetString("before")
+		as, _ := cmd.Flags().GetString("as")
+		registry, _ := cmd.Flags().GetString("registry")
+		namespace, _ := cmd.Flags().GetString("namespace")
+		dryRun, _ := cmd.Flags().GetString("dry-run")
+		jobs, _ := cmd.Flags().GetInt("jobs")
+		bwlimit, _ := cmd.Flags().GetString("bwlimit")
+		progress, _ := cmd.Flags().GetString("progress")
+		resume, _ := cmd.Flags().GetBool("resume")
+
+		if err := setTransferLimits(jobs, bwlimit); err != nil {
+			fmt.Fprintf(statusOut, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		if err := setProgressMode(progress); err != nil {
+			fmt.Fprintf(statusOut, "Error: %v\n", err)
+			os.Exit(1)
+		}
 
-		err := restoreModel(modelName, backupDir, ollamaDir, overwrite)
+		// A dry run prints its plan on stdout; status messages go to stderr so the JSON stays intact
+		dryRunFormat := ""
+		if cmd.Flags().Changed("dry-run") {
+			var err error
+			if dryRunFormat, err = parseDryRun(dryRun); err != nil {
+				fmt.Fprintf(statusOut, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			if dryRunFormat == "json" {
+				statusOut = os.Stderr
+			}
+		}
+
+		onConflict, err := resolveConflictPolicy(onConflict, cmd.Flags().Changed("on-conflict"), overwrite)
 		if err != nil {
-			fmt.Printf("Error restoring model: %v\n", err)
+			fmt.Fprintf(statusOut, "Error: %v\n", err)
 			os.Exit(1)
 		}
 
-		fmt.Printf("Model '%s' restored successfully from '%s'.\n", modelName, backupDir)
+		backupName, err := resolveBackupName(modelName, backupDir, at, before)
+		if err != nil {
+			fmt.Fprintf(statusOut, "Error finding backup: %v\n", err)
+			os.Exit(1)
+		}
+
+		opts := restoreOptions{
+			OllamaDir:  ollamaDir,
+			OnConflict: onConflict,
+			As:         as,
+			Registry:   registry,
+			Namespace:  namespace,
+			DryRun:     dryRunFormat != "",
+			Jobs:       jobs,
+			Resume:     resume,
+		}
+		plan, err := restoreModel(backupName, backupDir, opts)
+		if err != nil {
+			fmt.Fprintf(statusOut, "Error restoring model: %v\n", err)
+			os.Exit(1)
+		}
+
+		if opts.DryRun {
+			source := filepath.Join(backupDir, backupName)
+			if backupName == "-" {
+				source = "stdin"
+			}
+			if err := outputTransferPlans(os.Stdout, []*transferPlan{restoreTransferPlan(source, ollamaDir, plan)}, dryRunFormat); err != nil {
+				fmt.Fprintf(statusOut, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			return
+		}
+
+		if modelName == "-" {
+			fmt.Fprintln(statusOut, "Model restored successfully from stdin.")
+		} else {
+			fmt.Fprintf(statusOut, "Model '%s' restored successfully from '%s'.\n", modelName, backupDir)
+		}
 	},
 }
 
@@ -38,210 +122,686 @@ func init() {
 	rootCmd.AddCommand(restoreCmd)
 	restoreCmd.Flags().StringP("backup-dir", "d", "./backup", "Directory to restore from")
 	restoreCmd.Flags().String("ollama-dir", utils.GetOllamaDirectory(), "Ollama directory to restore data to")
-	restoreCmd.Flags().BoolP("overwrite", "o", false, "Overwrite existing files during restore")
+	restoreCmd.Flags().BoolP("overwrite", "o", false, "Same as --on-conflict overwrite")
+	restoreCmd.Flags().String("on-conflict", conflictFail, "What to do when a different manifest is installed under the same name: skip, overwrite, fail or rename")
+	restoreCmd.Flags().String("at", "", "Restore the backup taken at this time (unix time, RFC 3339, 'YYYY-MM-DD' or 'YYYY-MM-DD HH:MM[:SS]')")
+	restoreCmd.Flags().String("before", "", "Restore the newest backup taken before this time (same formats as --at)")
+	restoreCmd.Flags().String("as", "", "Restore the model under a different name ('[[registry/]namespace/]model[:version]'), reusing the same blobs")
+	restoreCmd.Flags().String("registry", "", "Restore the model into a different registry")
+	restoreCmd.Flags().String("namespace", "", "Restore the model into a different namespace")
+	restoreCmd.Flags().IntP("jobs", "j", 1, "Number of blobs copied at once from a backup directory; archives are read one blob at a time")
+	restoreCmd.Flags().String("bwlimit", "", "Cap the combined copy rate of all jobs in bytes per second, e.g. 500K, 100M or 1.5G")
+	restoreCmd.Flags().Bool("resume", false, "Continue an incomplete restore from a backup directory instead of starting over, and keep what was copied if it fails again")
+	restoreCmd.Flags().String("progress", progressAuto, "Report copy progress on stderr: auto (a bar on a terminal, log lines otherwise), bar, log, json or none")
+	restoreCmd.Flags().String("dry-run", "", "Print what would be restored without writing anything, as text or json")
+	restoreCmd.Flags().Lookup("dry-run").NoOptDefVal = "text"
 }
 
-// restoreModel handles restoring a model from a backup directory or zip file
-func restoreModel(modelName string, backupDir string, ollamaDir string, overwrite bool) error {
+// printBackupVersions lists the fully qualified model versions that have backups, with their number of backups
+func printBackupVersions(versions map[string][]string) {
+	var keys []string
+	for key := range versions {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(statusOut, "- %s (%d backups)\n", key, len(versions[key]))
+	}
+}
+
+// resolveBackupName finds the backup to restore for the given argument.
+// An existing backup directory or archive name, or "-" for stdin, is returned unchanged. Otherwise the argument is
+// treated as a model name and the newest matching backup is selected, optionally restricted by
+// the --at and --before selectors. Like validateModelName, the version may only be omitted if
+// backups of a single version exist; ambiguous selections fail with a list of candidates.
+func resolveBackupName(name, backupDir, at, before string) (string, error) {
+	if name == "-" || backupExists(name, backupDir) {
+		if at != "" || before != "" {
+			return "", fmt.Errorf("--at and --before select a backup by model name and cannot be used with backup '%s'", name)
+		}
+		return name, nil
+	}
+
+	ref, err := utils.ParseModelName(name)
+	if err != nil {
+		return "", err
+	}
+	if ref.Namespace == "" {
+		ref.Namespace = utils.DefaultNamespace
+	}
+
+	backups, err := scanBackups(backupDir)
+	if err != nil {
+		return "", err
+	}
+
+	// Collect the backups of the requested model
+	var candidates []backupEntry
+	versions := make(map[string][]string)
+	for _, backup := range backups {
+		if backup.Ref.Model != ref.Model || backup.Ref.Namespace != ref.Namespace {
+			continue
+		}
+		if ref.Registry != "" && backup.Ref.Registry != ref.Registry {
+			continue
+		}
+		if ref.Version != "" && backup.Ref.Version != ref.Version {
+			continue
+		}
+		candidates = append(candidates, backup)
+		key := backup.Ref.String()
+		versions[key] = append(versions[key], backup.Name)
+	}
+
+	if len(candidates) == 0 {
+		return "", fmt.Errorf("no backup found for model '%s' in '%s'", name, backupDir)
+	}
+
+	// The backups must belong to a single model, which a name without a registry may not identify
+	models := make(map[string]bool)
+	for _, backup := range candidates {
+		models[fmt.Sprintf("%s/%s/%s", backup.Ref.Registry, backup.Ref.Namespace, backup.Ref.Model)] = true
+	}
+	if len(models) > 1 {
+		fmt.Fprintf(statusOut, "Backups of %d different models found for '%s'. Please specify the model using its fully qualified name 'registry/namespace/model:version'.\n", len(models), name)
+		fmt.Fprintln(statusOut, "Available models:")
+		printBackupVersions(versions)
+		return "", fmt.Errorf("ambiguous model name '%s'", name)
+	}
+
+	// The backups must belong to a single model version
+	if len(versions) > 1 {
+		fmt.Fprintf(statusOut, "Backups of multiple versions found for model '%s'. Please specify a version using the format 'model:version'.\n", ref.Model)
+		fmt.Fprintln(statusOut, "Available versions:")
+		printBackupVersions(versions)
+		return "", fmt.Errorf("version not specified")
+	}
+	if ref.Version == "" {
+		fmt.Fprintf(statusOut, "Using version '%s' for model '%s'\n", candidates[0].Ref.Version, ref.Model)
+	}
+
+	sortBackupsNewestFirst(candidates)
+
+	// Apply the point-in-time selectors
+	if before != "" {
+		beforeTime, _, err := parseBackupTime(before)
+		if err != nil {
+			return "", err
+		}
+		var older []backupEntry
+		for _, backup := range candidates {
+			if backup.CreatedAt.Before(beforeTime) {
+				older = append(older, backup)
+			}
+		}
+		if len(older) == 0 {
+			printBackupCandidates(candidates)
+			return "", fmt.Errorf("no backup of '%s' was taken before %s", name, before)
+		}
+		candidates = older
+	}
+
+	if at != "" {
+		atTime, precision, err := parseBackupTime(at)
+		if err != nil {
+			return "", err
+		}
+		var matching []backupEntry
+		for _, backup := range candidates {
+			if !backup.CreatedAt.Before(atTime) && backup.CreatedAt.Before(atTime.Add(precision)) {
+				matching = append(matching, backup)
+			}
+		}
+		switch len(matching) {
+		case 0:
+			printBackupCandidates(candidates)
+			return "", fmt.Errorf("no backup of '%s' was taken at %s", name, at)
+		case 1:
+			candidates = matching
+		default:
+			printBackupCandidates(matching)
+			return "", fmt.Errorf("%d backups of '%s' were taken at %s; please use a more precise time", len(matching), name, at)
+		}
+	}
+
+	selected := candidates[0]
+	fmt.Fprintf(statusOut, "Using backup '%s' taken at %s\n", selected.Name, selected.CreatedAt.Local().Format(time.RFC3339))
+	return selected.Name, nil
+}
+
+// backupExists reports whether name is an existing backup directory, archive or repository snapshot in backupDir
+func backupExists(name, backupDir string) bool {
+	if _, err := os.Stat(filepath.Join(backupDir, name)); err == nil {
+		return true
+	}
+	if isRepository(backupDir) {
+		if _, err := os.Stat(filepath.Join(repositorySnapshotsDir(backupDir), name)); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// printBackupCandidates lists backups a selector could choose from
+func printBackupCandidates(backups []backupEntry) {
+	fmt.Fprintln(statusOut, "Available backups:")
+	for _, backup := range backups {
+		fmt.Fprintf(statusOut, "- %s (taken at %s)\n", backup.Name, backup.CreatedAt.Local().Format(time.RFC3339))
+	}
+}
+
+// Values of --on-conflict, deciding what happens to a manifest that differs from the installed one
+const (
+	conflictSkip      = "skip"      // Keep the installed manifest and don't restore this one
+	conflictOverwrite = "overwrite" // Replace the installed manifest
+	conflictFail      = "fail"      // Refuse the whole restore
+	conflictRename    = "rename"    // Restore the manifest under a free '<version>-restored[-N]' tag
+)
+
+// resolveConflictPolicy validates --on-conflict and folds the older --overwrite flag into it
+func resolveConflictPolicy(onConflict string, explicit, overwrite bool) (string, error) {
+	switch onConflict {
+	case conflictSkip, conflictOverwrite, conflictFail, conflictRename:
+	default:
+		return "", fmt.Errorf("invalid --on-conflict '%s': expected skip, overwrite, fail or rename", onConflict)
+	}
+	if overwrite {
+		if explicit && onConflict != conflictOverwrite {
+			return "", fmt.Errorf("--overwrite cannot be combined with --on-conflict %s", onConflict)
+		}
+		return conflictOverwrite, nil
+	}
+	return onConflict, nil
+}
+
+// restoreOptions holds the settings of a restore operation
+type restoreOptions struct {
+	OllamaDir  string // Ollama directory to restore data to
+	OnConflict string // What to do with manifests that differ from the installed ones, see conflictFail
+	DryRun     bool   // Only work out the restore plan without writing anything
+	Jobs       int    // Number of blobs copied at once from a backup directory
+	Resume     bool   // Continue an incomplete restore from a backup directory and keep it if it fails again
+	As         string // Restore the model under this '[[registry/]namespace/]model[:version]' name
+	Registry   string // Restore the model into this registry
+	Namespace  string // Restore the model into this namespace
+}
+
+// renames reports whether the restored model gets a different name than the one it was backed up under
+func (o restoreOptions) renames() bool {
+	return o.As != "" || o.Registry != "" || o.Namespace != ""
+}
+
+// Actions a restore or backup takes for a file, as shown by --dry-run
+const (
+	actionCopy      = "copy"      // The file is written
+	actionSkip      = "skip"      // An identical file is already in place
+	actionOverwrite = "overwrite" // The file replaces a different or corrupted one
+	actionKeep      = "keep"      // A different installed manifest is kept (--on-conflict skip)
+)
+
+// restoreManifest maps a manifest in the backup to the model name it is restored as
+type restoreManifest struct {
+	Source string         // Path of the manifest below library/manifests in the backup
+	Ref    utils.ModelRef // Model the manifest is restored as
+	Data   []byte         // Original manifest bytes
+	Action string         // What happens to the manifest, e.g. actionCopy
+}
+
+// restorePlan describes what a restore writes to the Ollama directory
+type restorePlan struct {
+	Manifests    []restoreManifest
+	Blobs        map[string]utils.BlobRef // Blobs to copy, keyed by blob file name
+	Installed    map[string]utils.BlobRef // Blobs that are already installed intact and reused
+	BlobsDir     string                   // Ollama blobs directory
+	ManifestsDir string                   // Ollama manifests directory
+	Replace      map[string]bool          // Paths of installed files the restore replaces
+}
+
+// writes reports whether the manifest is written to the Ollama directory
+func (m restoreManifest) writes() bool {
+	return m.Action == actionCopy || m.Action == actionOverwrite
+}
+
+// restoreModel handles restoring a model from a backup directory or archive, or from stdin for "-".
+// It returns the plan the restore followed; with opts.DryRun it returns the plan without writing anything.
+func restoreModel(modelName string, backupDir string, opts restoreOptions) (*restorePlan, error) {
+	// A backup piped in on stdin is restored while it streams
+	if modelName == "-" {
+		if opts.Resume {
+			return nil, fmt.Errorf("--resume continues restores from a backup directory; a backup streamed on stdin can't be resumed")
+		}
+		return restoreStream(os.Stdin, opts)
+	}
+
 	// Construct the full source path
 	sourcePath := filepath.Join(backupDir, modelName)
 
-	// Check if the source is a zip file
-	if strings.HasSuffix(modelName, ".zip") {
-		// Extract the zip file to the backup directory
-		extractedDir, err := unzipBackup(sourcePath, backupDir)
+	// An archive is restored straight into the Ollama directory without extracting it first,
+	// leaving the backup directory untouched
+	if info, err := os.Stat(sourcePath); err == nil && !info.IsDir() {
+		if opts.Resume {
+			return nil, fmt.Errorf("--resume continues restores from a backup directory; archives are read in a single pass and can't be resumed")
+		}
+		reader, format, err := openBackupArchive(sourcePath)
 		if err != nil {
-			return fmt.Errorf("failed to unzip backup: %w", err)
+			return nil, err
 		}
+		defer reader.Close()
+
+		fmt.Fprintf(statusOut, "Restoring directly from %s archive %s\n", format, sourcePath)
+		return restoreArchive(reader, opts)
+	}
 
-		// Update the source path to the extracted directory
-		sourcePath = extractedDir
-		fmt.Printf("Unzipped backup to: %s\n", extractedDir)
+	// Snapshots of a backup repository live in its snapshots directory
+	if _, err := os.Stat(sourcePath); os.IsNotExist(err) && isRepository(backupDir) {
+		sourcePath = filepath.Join(repositorySnapshotsDir(backupDir), modelName)
 	}
 
 	// Check if the source directory exists
 	sourceInfo, err := os.Stat(sourcePath)
 	if err != nil {
-		return fmt.Errorf("backup not found: %w", err)
+		return nil, fmt.Errorf("backup not found: %w", err)
 	}
 
 	// Ensure source is a directory
 	if !sourceInfo.IsDir() {
-		return fmt.Errorf("backup source is not a directory: %s", sourcePath)
+		return nil, fmt.Errorf("backup source is not a directory: %s", sourcePath)
 	}
 
+	// Describe the backup from its metadata, if it has any
+	meta, err := readBackupMetadata(sourcePath)
+	if err != nil {
+		return nil, err
+	}
+	printBackupProvenance(meta)
+
 	// Get paths to blobsDir and libraryDir
-	sourceBlobsDir := filepath.Join(sourcePath, "blobs")
+	sourceBlobsDir := backupBlobsDir(sourcePath)
 	sourceLibraryDir := filepath.Join(sourcePath, "library")
 
 	// Make sure these directories exist
 	if _, err := os.Stat(sourceBlobsDir); os.IsNotExist(err) {
-		return fmt.Errorf("blobs directory missing in backup: %s", sourceBlobsDir)
+		return nil, fmt.Errorf("blobs directory missing in backup: %s", sourceBlobsDir)
 	}
 
 	if _, err := os.Stat(sourceLibraryDir); os.IsNotExist(err) {
-		return fmt.Errorf("library directory missing in backup: %s", sourceLibraryDir)
+		return nil, fmt.Errorf("library directory missing in backup: %s", sourceLibraryDir)
+	}
+
+	manifests, err := readManifestsDir(filepath.Join(sourceLibraryDir, "manifests"))
+	if err != nil {
+		return nil, err
+	}
+
+	plan, err := planRestore(manifests, opts)
+	if err != nil || opts.DryRun {
+		return plan, err
+	}
+
+	// A resumable restore is recorded in a journal so a later attempt can continue where it stopped
+	var journal *runJournal
+	if opts.Resume {
+		journal = newRestoreJournal(sourcePath, opts.OllamaDir, plan)
+	}
+
+	// Nothing is written to the Ollama directory until every blob is staged and verified
+	txn, err := beginRestore(opts.OllamaDir, plan.Replace, journal)
+	if err != nil {
+		return nil, err
+	}
+	defer txn.Rollback()
+
+	// Stage blob files, verifying each one while it streams
+	if err := copyBlobsDirectory(sourceBlobsDir, txn, plan, opts.Jobs); err != nil {
+		return nil, fmt.Errorf("failed to copy blob files: %w", err)
+	}
+	fmt.Fprintln(statusOut, "Copied and verified blob files successfully")
+
+	return plan, installManifests(txn, plan)
+}
+
+// printBackupProvenance describes the backup from its metadata, if it has any
+func printBackupProvenance(meta *backupMetadata) {
+	if meta == nil {
+		return
+	}
+	fmt.Fprintf(statusOut, "Restoring %s (manifest %s), backed up on %s from %s at %s\n",
+		meta.Name, meta.ManifestDigest, meta.Hostname, meta.OllamaDir, meta.CreatedAt.Local().Format(time.RFC1123))
+}
+
+// planRestore works out which manifests and blobs a restore writes to the Ollama directory, given the
+// manifests of the backup keyed by their path below library/manifests. Blobs are content-addressed, so
+// installed blobs with the expected size and digest are reused and only corrupted ones are replaced.
+// Manifests that differ from the installed ones are handled according to --on-conflict. Unless it is a dry
+// run, the restore is refused if the Ollama directory lacks the space for the blobs to copy.
+// It doesn't write anything; see restoreTransaction.
+func planRestore(manifests map[string][]byte, opts restoreOptions) (*restorePlan, error) {
+	if len(manifests) == 0 {
+		return nil, fmt.Errorf("no manifests found in backup")
 	}
 
 	// Get target directories
-	ollamaBlobsDir := filepath.Join(ollamaDir, "models", "blobs")
-	ollamaManifestsDir := filepath.Join(ollamaDir, "models", "manifests")
+	ollamaBlobsDir := filepath.Join(opts.OllamaDir, "models", "blobs")
+	ollamaManifestsDir := filepath.Join(opts.OllamaDir, "models", "manifests")
+	replace := make(map[string]bool)
 
-	// Create target directories if they don't exist
-	if err := os.MkdirAll(ollamaBlobsDir, 0755); err != nil {
-		return fmt.Errorf("failed to create ollama blobs directory: %w", err)
+	// Work out where each manifest is restored to, applying --as, --registry and --namespace
+	planned, err := planManifests(manifests, opts)
+	if err != nil {
+		return nil, err
 	}
 
-	if err := os.MkdirAll(ollamaManifestsDir, 0755); err != nil {
-		return fmt.Errorf("failed to create ollama manifests directory: %w", err)
+	// Compare them against the installed manifests
+	planned, err = resolveManifestConflicts(planned, ollamaManifestsDir, opts.OnConflict, replace)
+	if err != nil {
+		return nil, err
 	}
 
-	// First check if files already exist (if not overwriting)
-	if !overwrite {
-		// Check blobs directory
-		blobsExist, err := checkFilesExist(sourceBlobsDir, ollamaBlobsDir)
-		if err != nil {
-			return err
-		}
-		if blobsExist {
-			return fmt.Errorf("some blob files already exist; use --overwrite to force restore")
+	// Collect the blobs referenced by the manifests to restore so every blob can be verified against its manifest digest.
+	// The blobs of an identical installed manifest are included, so a model with missing or corrupted blobs is repaired.
+	restored := make(map[string][]byte)
+	for _, manifest := range planned {
+		if manifest.Action != actionKeep {
+			restored[manifest.Source] = manifest.Data
 		}
+	}
+	blobRefs, err := collectManifestBlobs(restored)
+	if err != nil {
+		return nil, err
+	}
 
-		// Check library/manifests directory
-		manifestsExist, err := checkManifestsExist(sourceLibraryDir, ollamaManifestsDir)
-		if err != nil {
-			return err
-		}
-		if manifestsExist {
-			return fmt.Errorf("some manifest files already exist; use --overwrite to force restore")
+	remaining, err := skipInstalledBlobs(blobRefs, ollamaBlobsDir, replace)
+	if err != nil {
+		return nil, err
+	}
+	installed := make(map[string]utils.BlobRef)
+	for fileName, blob := range blobRefs {
+		if _, ok := remaining[fileName]; !ok {
+			installed[fileName] = blob
 		}
 	}
 
-	// Copy blob files
-	if err := copyDirectory(sourceBlobsDir, ollamaBlobsDir, overwrite); err != nil {
-		return fmt.Errorf("failed to copy blob files: %w", err)
+	plan := &restorePlan{
+		Manifests:    planned,
+		Blobs:        remaining,
+		Installed:    installed,
+		BlobsDir:     ollamaBlobsDir,
+		ManifestsDir: ollamaManifestsDir,
+		Replace:      replace,
 	}
-	fmt.Println("Copied blob files successfully")
 
-	// Copy library/manifests files
-	sourceManifestsDir := filepath.Join(sourceLibraryDir, "manifests")
-	if err := copyDirectory(sourceManifestsDir, ollamaManifestsDir, overwrite); err != nil {
-		return fmt.Errorf("failed to copy manifest files: %w", err)
+	// Refuse the restore before writing anything if the blobs that aren't installed yet don't fit
+	if !opts.DryRun {
+		if err := checkFreeSpace(opts.OllamaDir, plan.transferBytes()); err != nil {
+			return nil, err
+		}
 	}
-	fmt.Println("Copied manifest files successfully")
+	return plan, nil
+}
 
-	return nil
+// transferBytes returns the number of bytes the restore writes: the blobs to copy and the manifests to write
+func (p *restorePlan) transferBytes() int64 {
+	var total int64
+	for _, blob := range p.Blobs {
+		total += blob.Size
+	}
+	for _, manifest := range p.Manifests {
+		if manifest.writes() {
+			total += int64(len(manifest.Data))
+		}
+	}
+	return total
 }
 
-// unzipBackup extracts a zip file to the specified directory and returns the path to the extracted directory
-func unzipBackup(zipFile string, destDir string) (string, error) {
-	// Open the zip file
-	reader, err := zip.OpenReader(zipFile)
-	if err != nil {
-		return "", fmt.Errorf("failed to open zip file: %w", err)
+// modelNames lists the models the plan restores
+func (p *restorePlan) modelNames() string {
+	var models []string
+	for _, manifest := range p.Manifests {
+		models = append(models, manifest.Ref.String())
 	}
-	defer reader.Close()
+	return strings.Join(models, ", ")
+}
 
-	// Create a directory for the extracted contents
-	// Remove .zip extension to get the base name
-	baseName := strings.TrimSuffix(filepath.Base(zipFile), filepath.Ext(zipFile))
-	extractDir := filepath.Join(destDir, baseName)
+// beginProgress starts reporting the progress of copying the blobs of the plan; blobs that an earlier
+// attempt of the restore already staged aren't copied again
+func (p *restorePlan) beginProgress(txn *restoreTransaction) {
+	var total int64
+	var blobs int
+	for fileName, blob := range p.Blobs {
+		if !txn.journal.verified(fileName) {
+			total += blob.Size
+			blobs++
+		}
+	}
+	transferProgress.begin("restore", p.modelNames(), total, blobs)
+}
 
-	// Check if the directory already exists
-	if _, err := os.Stat(extractDir); err == nil {
-		// Directory exists, remove it
-		if err := os.RemoveAll(extractDir); err != nil {
-			return "", fmt.Errorf("failed to remove existing directory: %w", err)
+// newRestoreJournal creates the journal of a resumable restore of the plan from source into ollamaDir
+func newRestoreJournal(source, ollamaDir string, plan *restorePlan) *runJournal {
+	if abs, err := filepath.Abs(source); err == nil {
+		source = abs
+	}
+	if abs, err := filepath.Abs(ollamaDir); err == nil {
+		ollamaDir = abs
+	}
+	var manifests []string
+	for _, manifest := range plan.Manifests {
+		manifests = append(manifests, utils.BytesDigest(manifest.Data))
+	}
+	return newRunJournal("restore", plan.modelNames(), source, ollamaDir, manifests, sortedBlobNames(plan.Blobs))
+}
+
+// installManifests stages the manifests of a plan once all of its blobs are staged and commits the restore,
+// renaming the blobs into place first and the manifests last, so Ollama never sees a manifest whose blobs
+// are missing. If the commit fails, everything is rolled back.
+func installManifests(txn *restoreTransaction, plan *restorePlan) error {
+	for _, manifest := range plan.Manifests {
+		if !manifest.writes() {
+			continue
+		}
+		dstPath := filepath.Join(plan.ManifestsDir, filepath.FromSlash(manifest.Ref.ManifestPath()))
+		if err := txn.StageFile(dstPath, manifest.Data); err != nil {
+			return fmt.Errorf("failed to write manifest file: %w", err)
 		}
 	}
 
-	// Create the directory
-	if err := os.MkdirAll(extractDir, 0755); err != nil {
-		return "", fmt.Errorf("failed to create directory: %w", err)
+	if err := txn.Commit(); err != nil {
+		return fmt.Errorf("failed to install restored files: %w", err)
+	}
+	for _, manifest := range plan.Manifests {
+		if manifest.writes() {
+			fmt.Fprintf(statusOut, "Restored manifest as %s\n", manifest.Ref)
+		}
 	}
+	fmt.Fprintln(statusOut, "Copied manifest files successfully")
+	return nil
+}
 
-	// Extract each file
-	for _, file := range reader.File {
-		path := filepath.Join(extractDir, file.Name)
+// resolveManifestConflicts compares the manifests to restore against the ones installed in ollamaManifestsDir.
+// Identical manifests are skipped. Manifests that differ are kept, overwritten, renamed or refused according
+// to onConflict; the paths of manifests that get overwritten are added to replace.
+// It returns the manifests with the action the restore takes for each one.
+func resolveManifestConflicts(manifests []restoreManifest, ollamaManifestsDir, onConflict string, replace map[string]bool) ([]restoreManifest, error) {
+	var resolved []restoreManifest
+	var conflicts []string
+	for _, manifest := range manifests {
+		dstPath := filepath.Join(ollamaManifestsDir, filepath.FromSlash(manifest.Ref.ManifestPath()))
+		installed, err := os.ReadFile(dstPath)
+		if os.IsNotExist(err) {
+			manifest.Action = actionCopy
+			resolved = append(resolved, manifest)
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to read installed manifest: %w", err)
+		}
 
-		// Check if it's a directory
-		if file.FileInfo().IsDir() {
-			if err := os.MkdirAll(path, file.Mode()); err != nil {
-				return "", fmt.Errorf("failed to create directory: %w", err)
-			}
+		if bytes.Equal(installed, manifest.Data) {
+			fmt.Fprintf(statusOut, "Manifest for %s is already installed\n", manifest.Ref)
+			manifest.Action = actionSkip
+			resolved = append(resolved, manifest)
 			continue
 		}
 
-		// Create the file
-		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
-			return "", fmt.Errorf("failed to create directory: %w", err)
+		switch onConflict {
+		case conflictSkip:
+			fmt.Fprintf(statusOut, "Keeping the installed manifest for %s, which differs from the backup\n", manifest.Ref)
+			manifest.Action = actionKeep
+			resolved = append(resolved, manifest)
+		case conflictOverwrite:
+			fmt.Fprintf(statusOut, "Overwriting the installed manifest for %s\n", manifest.Ref)
+			replace[dstPath] = true
+			manifest.Action = actionOverwrite
+			resolved = append(resolved, manifest)
+		case conflictRename:
+			ref, err := freeRestoredName(manifest.Ref, ollamaManifestsDir)
+			if err != nil {
+				return nil, err
+			}
+			fmt.Fprintf(statusOut, "A different manifest is installed for %s, restoring as %s\n", manifest.Ref, ref)
+			manifest.Ref = ref
+			manifest.Action = actionCopy
+			resolved = append(resolved, manifest)
+		default:
+			fmt.Fprintf(statusOut, "Manifest already exists with different content: %s\n", dstPath)
+			conflicts = append(conflicts, manifest.Ref.String())
 		}
+	}
 
-		outFile, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, file.Mode())
-		if err != nil {
-			return "", fmt.Errorf("failed to create file: %w", err)
+	if len(conflicts) > 0 {
+		return nil, fmt.Errorf("%d manifest(s) differ from the installed ones; use --on-conflict skip, overwrite or rename", len(conflicts))
+	}
+	return resolved, nil
+}
+
+// freeRestoredName returns ref with its version changed to '<version>-restored', or '<version>-restored-N'
+// for the smallest N >= 2 that isn't installed yet
+func freeRestoredName(ref utils.ModelRef, ollamaManifestsDir string) (utils.ModelRef, error) {
+	version := ref.Version
+	for n := 1; ; n++ {
+		ref.Version = version + "-restored"
+		if n > 1 {
+			ref.Version = fmt.Sprintf("%s-restored-%d", version, n)
 		}
+		_, err := os.Stat(filepath.Join(ollamaManifestsDir, filepath.FromSlash(ref.ManifestPath())))
+		if os.IsNotExist(err) {
+			break
+		} else if err != nil {
+			return utils.ModelRef{}, err
+		}
+	}
 
-		rc, err := file.Open()
+	// Validate the resulting name the same way a name given on the command line is validated
+	if _, err := utils.ParseModelName(ref.String()); err != nil {
+		return utils.ModelRef{}, err
+	}
+	return ref, nil
+}
+
+// skipInstalledBlobs returns blobRefs without the blobs that already exist in ollamaBlobsDir with the expected
+// size and digest. Blobs are named after their digest, so such a blob is identical to the one in the backup.
+// Installed blobs that don't match are corrupted; their paths are added to replace so they are restored.
+func skipInstalledBlobs(blobRefs map[string]utils.BlobRef, ollamaBlobsDir string, replace map[string]bool) (map[string]utils.BlobRef, error) {
+	remaining := make(map[string]utils.BlobRef)
+	for _, fileName := range sortedBlobNames(blobRefs) {
+		blob := blobRefs[fileName]
+		expected, err := utils.ExpectedDigest(fileName, blob.Digest)
 		if err != nil {
-			outFile.Close()
-			return "", fmt.Errorf("failed to open file in zip: %w", err)
+			return nil, err
 		}
 
-		_, err = io.Copy(outFile, rc)
-		outFile.Close()
-		rc.Close()
+		dstPath := filepath.Join(ollamaBlobsDir, fileName)
+		info, err := os.Stat(dstPath)
+		if os.IsNotExist(err) {
+			remaining[fileName] = blob
+			continue
+		} else if err != nil {
+			return nil, err
+		}
 
-		if err != nil {
-			return "", fmt.Errorf("failed to copy file content: %w", err)
+		// Check the size before hashing, so an obviously truncated blob isn't read in full
+		if blob.Size <= 0 || info.Size() == blob.Size {
+			digest, _, err := utils.FileDigest(dstPath)
+			if err != nil {
+				return nil, err
+			}
+			if digest == expected {
+				fmt.Fprintf(statusOut, "Reusing installed blob: %s\n", fileName)
+				continue
+			}
 		}
-	}
 
-	return extractDir, nil
+		fmt.Fprintf(statusOut, "Replacing corrupted installed blob: %s\n", fileName)
+		replace[dstPath] = true
+		remaining[fileName] = blob
+	}
+	return remaining, nil
 }
 
-// checkFilesExist checks if any file in sourcePath already exists in destPath
-func checkFilesExist(sourcePath, destPath string) (bool, error) {
-	// Walk through the source directory
-	return walkAndCheck(sourcePath, func(relPath string) (bool, error) {
-		// Check if file exists in destination
-		destFile := filepath.Join(destPath, relPath)
-		if _, err := os.Stat(destFile); err == nil {
-			fmt.Printf("File already exists: %s\n", destFile)
-			return true, nil
-		} else if !os.IsNotExist(err) {
-			return false, err
-		}
-		return false, nil
-	})
-}
+// planManifests lists the manifests of a backup, keyed by their path below library/manifests, together with
+// the model each one is restored as. Renaming with --as, --registry or --namespace requires the backup to
+// contain exactly one manifest.
+func planManifests(manifests map[string][]byte, opts restoreOptions) ([]restoreManifest, error) {
+	relPaths := make([]string, 0, len(manifests))
+	for relPath := range manifests {
+		relPaths = append(relPaths, relPath)
+	}
+	sort.Strings(relPaths)
 
-// checkManifestsExist checks if any manifest file in sourceLibraryDir already exists in ollamaManifestsDir
-func checkManifestsExist(sourceLibraryDir, ollamaManifestsDir string) (bool, error) {
-	// Walk through the source manifests directory
-	sourceManifestsDir := filepath.Join(sourceLibraryDir, "manifests")
-	if _, err := os.Stat(sourceManifestsDir); os.IsNotExist(err) {
-		return false, nil
+	var planned []restoreManifest
+	for _, relPath := range relPaths {
+		ref, ok := utils.ParseManifestPath(relPath)
+		if !ok {
+			return nil, fmt.Errorf("unexpected manifest path in backup: %s", relPath)
+		}
+		planned = append(planned, restoreManifest{Source: relPath, Ref: ref, Data: manifests[relPath]})
 	}
 
-	return walkAndCheck(sourceManifestsDir, func(relPath string) (bool, error) {
-		// Check if file exists in destination
-		destFile := filepath.Join(ollamaManifestsDir, relPath)
-		if _, err := os.Stat(destFile); err == nil {
-			fmt.Printf("Manifest already exists: %s\n", destFile)
-			return true, nil
-		} else if !os.IsNotExist(err) {
-			return false, err
+	if !opts.renames() {
+		return planned, nil
+	}
+	if len(planned) != 1 {
+		return nil, fmt.Errorf("--as, --registry and --namespace require a backup with exactly one manifest, found %d", len(planned))
+	}
+
+	ref := planned[0].Ref
+	if opts.As != "" {
+		as, err := utils.ParseModelName(opts.As)
+		if err != nil {
+			return nil, err
 		}
-		return false, nil
-	})
+		ref.Model = as.Model
+		if as.Version != "" {
+			ref.Version = as.Version
+		}
+		if as.Namespace != "" {
+			ref.Namespace = as.Namespace
+		}
+		if as.Registry != "" {
+			ref.Registry = as.Registry
+		}
+	}
+	if opts.Registry != "" {
+		ref.Registry = opts.Registry
+	}
+	if opts.Namespace != "" {
+		ref.Namespace = opts.Namespace
+	}
+
+	// Validate the resulting name the same way a name given on the command line is validated
+	if _, err := utils.ParseModelName(ref.String()); err != nil {
+		return nil, err
+	}
+
+	fmt.Fprintf(statusOut, "Restoring %s as %s\n", planned[0].Ref, ref)
+	planned[0].Ref = ref
+	return planned, nil
 }
 
-// walkAndCheck walks a directory and applies a check function to each file
-func walkAndCheck(rootPath string, checkFn func(string) (bool, error)) (bool, error) {
-	exists := false
+// readManifestsDir reads every manifest below manifestsDir, keyed by its path relative to manifestsDir using '/' as separator
+func readManifestsDir(manifestsDir string) (map[string][]byte, error) {
+	manifests := make(map[string][]byte)
 
-	err := filepath.Walk(rootPath, func(path string, info os.FileInfo, err error) error {
+	err := filepath.Walk(manifestsDir, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
@@ -251,65 +811,95 @@ func walkAndCheck(rootPath string, checkFn func(string) (bool, error)) (bool, er
 			return nil
 		}
 
-		// Get relative path
-		relPath, err := filepath.Rel(rootPath, path)
+		relPath, err := filepath.Rel(manifestsDir, path)
 		if err != nil {
 			return err
 		}
 
-		// Apply check function
-		fileExists, err := checkFn(relPath)
+		content, err := os.ReadFile(path)
 		if err != nil {
-			return err
+			return fmt.Errorf("failed to read manifest %s: %w", path, err)
 		}
-
-		if fileExists {
-			exists = true
-			// Don't stop the walk, continue checking other files
-		}
-
+		manifests[filepath.ToSlash(relPath)] = content
 		return nil
 	})
+	if err != nil {
+		return nil, err
+	}
 
-	return exists, err
+	return manifests, nil
 }
 
-// copyDirectory copies files from src to dst
-func copyDirectory(src, dst string, overwrite bool) error {
-	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
+// collectManifestBlobs parses the manifests of a backup and returns the referenced blobs keyed by blob file name
+func collectManifestBlobs(manifests map[string][]byte) (map[string]utils.BlobRef, error) {
+	blobRefs := make(map[string]utils.BlobRef)
 
-		// Skip directories
-		if info.IsDir() {
-			return nil
+	for relPath, content := range manifests {
+		var manifest map[string]interface{}
+		if err := json.Unmarshal(content, &manifest); err != nil {
+			return nil, fmt.Errorf("failed to parse manifest %s: %w", relPath, err)
 		}
 
-		// Get relative path
-		relPath, err := filepath.Rel(src, path)
-		if err != nil {
-			return err
+		for _, blob := range utils.ManifestBlobs(manifest) {
+			blobRefs[blob.FileName()] = blob
 		}
+	}
+
+	return blobRefs, nil
+}
 
-		// Construct destination path
-		dstPath := filepath.Join(dst, relPath)
+// copyBlobsDirectory stages the blob files of a plan from src, verifying each one against the digest and
+// size recorded in its manifest and against its "sha256-<hex>" file name. Up to jobs blobs are copied at once.
+// All blobs are checked before an error is returned so the report covers every corrupted or missing blob.
+func copyBlobsDirectory(src string, txn *restoreTransaction, plan *restorePlan, jobs int) error {
+	fileNames := sortedBlobNames(plan.Blobs)
+	failures := make([]error, len(fileNames))
+	plan.beginProgress(txn)
+	err := runParallel(jobs, len(fileNames), func(ctx context.Context, i int) error {
+		fileName := fileNames[i]
+		blob := plan.Blobs[fileName]
+		dst := filepath.Join(plan.BlobsDir, fileName)
+		if txn.StageVerified(dst, blob) {
+			fmt.Fprintf(statusOut, "Keeping blob verified by an earlier attempt: %s\n", fileName)
+			return nil
+		}
 
-		// Create destination directory if it doesn't exist
-		if err := os.MkdirAll(filepath.Dir(dstPath), 0755); err != nil {
+		sourceFile, err := os.Open(filepath.Join(src, fileName))
+		if os.IsNotExist(err) {
+			// Every blob referenced by a manifest must be present in the backup
+			failures[i] = fmt.Errorf("blob %s is referenced by a manifest but missing from the backup", fileName)
+			return nil
+		} else if err != nil {
 			return err
 		}
-
-		// Check if destination file exists
-		if !overwrite {
-			if _, err := os.Stat(dstPath); err == nil {
-				return fmt.Errorf("destination file already exists: %s", dstPath)
-			} else if !os.IsNotExist(err) {
-				return err
-			}
+		defer sourceFile.Close()
+
+		// Copy the blob, hashing it while it streams
+		progress := transferProgress.startBlob(fileName, blob.Size)
+		defer progress.finish()
+		err = txn.StageBlob(dst, blob, func(offset int64) (io.Reader, error) {
+			return openTransferAt(ctx, sourceFile, offset, progress)
+		})
+		if isVerificationError(err) {
+			failures[i] = err
+			return nil
 		}
-
-		// Copy the file
-		return copyFile(path, dstPath)
+		return err
 	})
+	transferProgress.end()
+	if err != nil {
+		return err
+	}
+
+	return reportBlobFailures(compactErrors(failures))
+}
+
+// sortedBlobNames returns the blob file names in blobRefs in sorted order
+func sortedBlobNames(blobRefs map[string]utils.BlobRef) []string {
+	fileNames := make([]string, 0, len(blobRefs))
+	for fileName := range blobRefs {
+		fileNames = append(fileNames, fileName)
+	}
+	sort.Strings(fileNames)
+	return fileNames
 }
@@ -0,0 +1,139 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// restoreStream restores a backup archive read sequentially from r, such as stdin
+func restoreStream(r io.Reader, opts restoreOptions) (*restorePlan, error) {
+	reader, format, err := newArchiveStreamReader(r, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	fmt.Fprintf(statusOut, "Restoring %s archive from stdin\n", format)
+	return restoreArchive(reader, opts)
+}
+
+// restoreArchive restores a backup while reading its archive in a single pass, from a file or a stream.
+// Archives with entries rejected by validateArchiveEntry are refused as soon as such an entry is read.
+// backup.json and the manifests must come before the blobs, as backupModel writes them, so every blob can be
+// verified against its manifest while it streams into the staging directory of a restoreTransaction.
+// Nothing is extracted next to the archive. Like a restore from a directory, the restore is only committed
+// if every blob arrived intact, and is rolled back otherwise. With opts.DryRun, reading stops at the first
+// blob and the plan is returned without writing anything.
+func restoreArchive(reader archiveReader, opts restoreOptions) (*restorePlan, error) {
+	manifests := make(map[string][]byte)
+	received := make(map[string]bool)
+	var plan *restorePlan
+	var txn *restoreTransaction
+	var failures []error
+	defer func() {
+		transferProgress.end()
+		if txn != nil {
+			txn.Rollback()
+		}
+	}()
+
+	for {
+		entry, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := validateArchiveEntry(entry); err != nil {
+			return nil, err
+		}
+		if entry.Mode.IsDir() {
+			continue
+		}
+
+		switch {
+		case entry.Name == backupMetadataFile:
+			content, err := readArchiveEntry(entry)
+			if err != nil {
+				return nil, err
+			}
+			meta, err := parseBackupMetadata(content)
+			if err != nil {
+				return nil, err
+			}
+			printBackupProvenance(meta)
+
+		case strings.HasPrefix(entry.Name, "library/manifests/"):
+			if plan != nil {
+				return nil, fmt.Errorf("manifest %s comes after the blobs in the archive; it can only be restored from an extracted copy", entry.Name)
+			}
+			content, err := readArchiveEntry(entry)
+			if err != nil {
+				return nil, err
+			}
+			manifests[strings.TrimPrefix(entry.Name, "library/manifests/")] = content
+
+		case isBlobEntry(entry.Name):
+			// All manifests have been read once the first blob arrives
+			if plan == nil {
+				if plan, err = planRestore(manifests, opts); err != nil || opts.DryRun {
+					return plan, err
+				}
+				if txn, err = beginRestore(opts.OllamaDir, plan.Replace, nil); err != nil {
+					return nil, err
+				}
+				plan.beginProgress(txn)
+			}
+
+			fileName := path.Base(entry.Name)
+			blob, ok := plan.Blobs[fileName]
+			if !ok || received[fileName] {
+				// Not referenced by any manifest, or already installed and reused
+				continue
+			}
+			received[fileName] = true
+
+			progress := transferProgress.startBlob(fileName, blob.Size)
+			err = txn.StageBlob(filepath.Join(plan.BlobsDir, fileName), blob, func(int64) (io.Reader, error) {
+				return newTransferReader(context.Background(), entry.Reader, progress), nil
+			})
+			progress.finish()
+			if err != nil {
+				if isVerificationError(err) {
+					failures = append(failures, err)
+					continue
+				}
+				return nil, fmt.Errorf("failed to copy blob file: %w", err)
+			}
+		}
+	}
+	transferProgress.end()
+
+	if plan == nil {
+		var err error
+		if plan, err = planRestore(manifests, opts); err != nil || opts.DryRun {
+			return plan, err
+		}
+		if txn, err = beginRestore(opts.OllamaDir, plan.Replace, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	// Every blob referenced by a manifest must have been in the archive
+	for _, fileName := range sortedBlobNames(plan.Blobs) {
+		if !received[fileName] {
+			failures = append(failures, fmt.Errorf("blob %s is referenced by a manifest but missing from the backup", fileName))
+		}
+	}
+	if err := reportBlobFailures(failures); err != nil {
+		return nil, fmt.Errorf("failed to copy blob files: %w", err)
+	}
+	fmt.Fprintln(statusOut, "Copied and verified blob files successfully")
+
+	return plan, installManifests(txn, plan)
+}
@@ -0,0 +1,342 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+
+	"backup_ollama/internal/utils"
+)
+
+// restoreTransaction makes a restore all-or-nothing. Blobs and manifests are first written to a staging
+// directory next to the Ollama blobs and manifests directories, fsynced and verified. Commit then renames
+// them into place, blobs first and manifests last, so Ollama never sees a manifest whose blobs are missing
+// or truncated. If anything fails, Rollback undoes every rename and removes what the restore created,
+// leaving the store as it was. Interrupting the restore with Ctrl-C or SIGTERM rolls it back too.
+type restoreTransaction struct {
+	mu          sync.Mutex // Held while committing or rolling back, so an interrupt can't split a commit
+	stageMu     sync.Mutex // Guards staged and temps, as blobs are staged by several jobs at once
+	temps       int        // Number of temporary files created, used to name them uniquely
+	interrupts  chan os.Signal
+	modelsDir   string
+	stagingDir  string
+	replace     map[string]bool // Installed files the restore may replace
+	staged      []stagedFile
+	committed   []committedFile
+	createdDirs []string    // Directories created by the restore, parents first
+	journal     *runJournal // Journal of a resumable restore, nil otherwise
+	committing  bool
+	done        bool
+}
+
+// stagedFile is a verified temporary file waiting to be renamed to its final path
+type stagedFile struct {
+	Temp  string
+	Final string
+}
+
+// committedFile is a file renamed into place, and where the file it replaced was moved aside to (if any)
+type committedFile struct {
+	Final string
+	Aside string
+}
+
+// beginRestore starts a restore into the Ollama models directory below ollamaDir.
+// The staging directory lives in the models directory so renames never cross file systems,
+// but outside of blobs/ and manifests/ so Ollama never picks up a half-written file.
+// Only the installed files listed in replace may be replaced.
+//
+// With a journal the restore is resumable: the staging directory of an earlier attempt with a matching
+// journal is reused, and if the restore fails before it is committed, the staging directory is kept with
+// its verified blobs and partial copies instead of being removed.
+func beginRestore(ollamaDir string, replace map[string]bool, journal *runJournal) (*restoreTransaction, error) {
+	t := &restoreTransaction{modelsDir: filepath.Join(ollamaDir, "models"), replace: replace}
+	if err := t.mkdirAll(t.modelsDir); err != nil {
+		return nil, fmt.Errorf("failed to create ollama models directory: %w", err)
+	}
+
+	if journal != nil {
+		if stagingDir, earlier := findIncompleteRestore(t.modelsDir, journal); earlier != nil {
+			t.stagingDir, journal = stagingDir, earlier
+			fmt.Fprintf(statusOut, "Resuming incomplete restore (attempt %d, %d of %d blobs verified)\n", journal.Attempts+1, journal.verifiedCount(), len(journal.Blobs))
+		}
+	}
+	if t.stagingDir == "" {
+		stagingDir, err := os.MkdirTemp(t.modelsDir, ".restore-")
+		if err != nil {
+			t.Rollback()
+			return nil, fmt.Errorf("failed to create staging directory: %w", err)
+		}
+		t.stagingDir = stagingDir
+	}
+	if journal != nil {
+		t.journal = journal
+		if err := journal.start(t.stagingDir); err != nil {
+			t.Rollback()
+			return nil, err
+		}
+	}
+
+	t.interrupts = make(chan os.Signal, 1)
+	signal.Notify(t.interrupts, os.Interrupt, syscall.SIGTERM)
+	go t.rollbackOnInterrupt()
+	return t, nil
+}
+
+// rollbackOnInterrupt rolls the restore back and exits when the process is interrupted before the restore
+// is committed or rolled back
+func (t *restoreTransaction) rollbackOnInterrupt() {
+	if _, ok := <-t.interrupts; !ok {
+		return
+	}
+	t.mu.Lock()
+	committed := t.done
+	t.rollbackLocked()
+	t.mu.Unlock()
+
+	if committed {
+		fmt.Fprintln(os.Stderr, "Interrupted after the restore was committed")
+	} else {
+		fmt.Fprintln(os.Stderr, "Interrupted, restore rolled back")
+	}
+	os.Exit(130)
+}
+
+// findIncompleteRestore looks for the staging directory of an earlier attempt of the restore described by
+// journal, and returns it with that attempt's journal
+func findIncompleteRestore(modelsDir string, journal *runJournal) (string, *runJournal) {
+	dirs, _ := filepath.Glob(filepath.Join(modelsDir, ".restore-*"))
+	for _, dir := range dirs {
+		earlier, err := loadRunJournal(dir)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+			continue
+		}
+		if earlier != nil && journal.matches(earlier) {
+			return dir, earlier
+		}
+	}
+	return "", nil
+}
+
+// finish stops listening for interrupts once the restore is committed or rolled back
+func (t *restoreTransaction) finish() {
+	t.done = true
+	if t.interrupts != nil {
+		signal.Stop(t.interrupts)
+		close(t.interrupts)
+		t.interrupts = nil
+	}
+}
+
+// StageBlob streams a blob from open into the staging directory, verifying it against its expected digest
+// and size, and syncs it to disk. On a mismatch a *utils.DigestMismatchError or *utils.SizeMismatchError
+// is returned and nothing is staged. A resumable restore continues the partial copy of an earlier attempt.
+// It may be called by several goroutines at once.
+func (t *restoreTransaction) StageBlob(dst string, blob utils.BlobRef, open blobOpener) error {
+	fileName := filepath.Base(dst)
+	temp := filepath.Join(t.stagingDir, fileName)
+	if err := t.journal.setBlob(fileName, journalBlobCopying); err != nil {
+		return err
+	}
+	if err := copyBlobPartial(temp+".partial", blob, fileName, open, t.journal != nil); err != nil {
+		return err
+	}
+	if err := os.Rename(temp+".partial", temp); err != nil {
+		return err
+	}
+	if err := t.journal.setBlob(fileName, journalBlobVerified); err != nil {
+		return err
+	}
+
+	t.stage(temp, dst)
+	return nil
+}
+
+// StageVerified stages a blob that an earlier attempt of a resumed restore already copied and verified,
+// and reports whether there was one
+func (t *restoreTransaction) StageVerified(dst string, blob utils.BlobRef) bool {
+	fileName := filepath.Base(dst)
+	if !t.journal.verified(fileName) || !repositoryHasBlob(t.stagingDir, blob) {
+		return false
+	}
+	t.stage(filepath.Join(t.stagingDir, fileName), dst)
+	return true
+}
+
+// StageFile writes data such as a manifest to the staging directory and syncs it to disk
+func (t *restoreTransaction) StageFile(dst string, data []byte) error {
+	temp := t.tempPath(dst)
+	file, err := os.Create(temp)
+	if err != nil {
+		return err
+	}
+
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(temp)
+		return err
+	}
+
+	t.stage(temp, dst)
+	return nil
+}
+
+// tempPath returns a unique path in the staging directory for a file that ends up at dst
+func (t *restoreTransaction) tempPath(dst string) string {
+	t.stageMu.Lock()
+	defer t.stageMu.Unlock()
+	t.temps++
+	return filepath.Join(t.stagingDir, fmt.Sprintf("%d-%s", t.temps, filepath.Base(dst)))
+}
+
+// stage records a verified temporary file to be renamed to dst on Commit
+func (t *restoreTransaction) stage(temp, dst string) {
+	t.stageMu.Lock()
+	defer t.stageMu.Unlock()
+	t.staged = append(t.staged, stagedFile{Temp: temp, Final: dst})
+}
+
+// Commit renames the staged files into place in the order they were staged, which is blobs before
+// manifests. Files that are replaced are moved aside until the whole restore is committed.
+// If any rename fails, the restore is rolled back and the error is returned.
+func (t *restoreTransaction) Commit() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.done {
+		return fmt.Errorf("restore was already rolled back")
+	}
+	// From here on a failure rolls back completely, even for a resumable restore
+	t.committing = true
+
+	for i, staged := range t.staged {
+		if err := t.commitFile(i, staged); err != nil {
+			t.rollbackLocked()
+			return err
+		}
+	}
+
+	// Make the renames durable before the files they replaced are dropped
+	synced := make(map[string]bool)
+	for _, committed := range t.committed {
+		dir := filepath.Dir(committed.Final)
+		if !synced[dir] {
+			syncDir(dir)
+			synced[dir] = true
+		}
+	}
+
+	t.finish()
+	os.RemoveAll(t.stagingDir)
+	return nil
+}
+
+// commitFile renames a single staged file into place
+func (t *restoreTransaction) commitFile(index int, staged stagedFile) error {
+	if err := t.mkdirAll(filepath.Dir(staged.Final)); err != nil {
+		return fmt.Errorf("failed to create directory for %s: %w", staged.Final, err)
+	}
+
+	committed := committedFile{Final: staged.Final}
+	if _, err := os.Lstat(staged.Final); err == nil {
+		if !t.replace[staged.Final] {
+			return fmt.Errorf("%s appeared while restoring", staged.Final)
+		}
+		committed.Aside = filepath.Join(t.stagingDir, fmt.Sprintf("replaced-%d", index))
+		if err := os.Rename(staged.Final, committed.Aside); err != nil {
+			return fmt.Errorf("failed to move %s aside: %w", staged.Final, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	if err := os.Rename(staged.Temp, staged.Final); err != nil {
+		if committed.Aside != "" {
+			os.Rename(committed.Aside, staged.Final)
+		}
+		return fmt.Errorf("failed to move %s into place: %w", staged.Final, err)
+	}
+	t.committed = append(t.committed, committed)
+	return nil
+}
+
+// Rollback undoes everything the restore did: committed files are removed again and the files they
+// replaced are put back, then the staging directory and any directories the restore created are removed.
+// It does nothing once the restore has been committed.
+func (t *restoreTransaction) Rollback() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.rollbackLocked()
+}
+
+func (t *restoreTransaction) rollbackLocked() {
+	if t.done {
+		return
+	}
+	t.finish()
+
+	for i := len(t.committed) - 1; i >= 0; i-- {
+		committed := t.committed[i]
+		os.Remove(committed.Final)
+		if committed.Aside != "" {
+			os.Rename(committed.Aside, committed.Final)
+		}
+	}
+	if t.journal != nil && !t.committing {
+		// Nothing was moved into place yet; keep what was staged for the next attempt
+		t.journal.fail()
+		fmt.Fprintf(os.Stderr, "Kept %d verified blob(s) in %s; run the restore again with --resume to continue it\n", t.journal.verifiedCount(), t.stagingDir)
+	} else if t.stagingDir != "" {
+		os.RemoveAll(t.stagingDir)
+	}
+	for i := len(t.createdDirs) - 1; i >= 0; i-- {
+		// Only removes directories that are still empty
+		os.Remove(t.createdDirs[i])
+	}
+
+	if len(t.committed) > 0 {
+		fmt.Fprintf(os.Stderr, "Rolled back %d restored file(s)\n", len(t.committed))
+	}
+}
+
+// mkdirAll creates dir and any missing parents, remembering which ones it created
+func (t *restoreTransaction) mkdirAll(dir string) error {
+	var missing []string
+	for d := dir; ; d = filepath.Dir(d) {
+		if _, err := os.Stat(d); err == nil {
+			break
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+		missing = append(missing, d)
+		if filepath.Dir(d) == d {
+			break
+		}
+	}
+
+	for i := len(missing) - 1; i >= 0; i-- {
+		if err := os.Mkdir(missing[i], 0755); err != nil && !os.IsExist(err) {
+			return err
+		}
+		t.createdDirs = append(t.createdDirs, missing[i])
+	}
+	return nil
+}
+
+// syncDir flushes a directory so renames into it survive a crash.
+// This is best effort: not every platform supports syncing a directory.
+func syncDir(dir string) {
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+}
@@ -1,13 +1,25 @@
 package cmd
 
 import (
+	"io"
+	"os"
+
 	"github.com/spf13/cobra"
 )
 
+// Version is the version of the application, set at build time with
+// -ldflags "-X backup_ollama/cmd.Version=v1.2.3"
+var Version = "dev"
+
+// statusOut receives the status messages of backups and restores. It is stdout unless stdout carries an
+// archive stream or a JSON dry-run plan, in which case the messages go to stderr to keep that output intact.
+var statusOut io.Writer = os.Stdout
+
 var rootCmd = &cobra.Command{
-	Use:   "backup_ollama",
-	Short: "A command-line application for backing up and restoring models",
-	Long:  `This application allows you to backup and restore models with specified names.`,
+	Use:     "backup_ollama",
+	Short:   "A command-line application for backing up and restoring models",
+	Long:    `This application allows you to backup and restore models with specified names.`,
+	Version: Version,
 }
 
 // Execute runs the root command.
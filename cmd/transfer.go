@@ -0,0 +1,179 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// transferLimiter caps the combined bandwidth of all blob copies, nil if --bwlimit isn't set
+var transferLimiter *rateLimiter
+
+// parseByteRate parses a --bwlimit value such as '500K', '100M', '1.5G' or '100MB/s' into bytes per second.
+// Units are powers of 1024 like the sizes the tool prints; a plain number is bytes per second.
+func parseByteRate(value string) (float64, error) {
+	s := strings.ToUpper(strings.TrimSpace(value))
+	s = strings.TrimSuffix(s, "/S")
+	s = strings.TrimSuffix(s, "IB")
+	s = strings.TrimSuffix(s, "B")
+
+	multiplier := 1.0
+	if n := len(s); n > 0 {
+		if i := strings.IndexByte("KMGT", s[n-1]); i >= 0 {
+			for ; i >= 0; i-- {
+				multiplier *= 1024
+			}
+			s = s[:n-1]
+		}
+	}
+
+	rate, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil || rate <= 0 {
+		return 0, fmt.Errorf("invalid bandwidth limit '%s': expected a rate such as 500K, 100M or 1.5G per second", value)
+	}
+	return rate * multiplier, nil
+}
+
+// setTransferLimits validates --jobs and installs the --bwlimit rate shared by every blob copy of the run.
+// An empty bandwidth limit means no limit.
+func setTransferLimits(jobs int, bwlimit string) error {
+	if jobs < 1 {
+		return fmt.Errorf("invalid --jobs %d: at least one job is required", jobs)
+	}
+	if bwlimit == "" {
+		return nil
+	}
+	rate, err := parseByteRate(bwlimit)
+	if err != nil {
+		return err
+	}
+	transferLimiter = &rateLimiter{rate: rate}
+	return nil
+}
+
+// runParallel calls fn for every index below n, with at most jobs calls running at once. The first error
+// returned by fn cancels the context passed to the other calls, no further calls are started, and the error
+// is returned once every running call has stopped.
+func runParallel(jobs, n int, fn func(ctx context.Context, i int) error) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		once     sync.Once
+		firstErr error
+	)
+	indexes := make(chan int)
+	for w := 0; w < jobs && w < n; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				if err := fn(ctx, i); err != nil {
+					once.Do(func() {
+						firstErr = err
+						cancel()
+					})
+				}
+			}
+		}()
+	}
+
+feed:
+	for i := 0; i < n; i++ {
+		select {
+		case indexes <- i:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(indexes)
+	wg.Wait()
+	return firstErr
+}
+
+// transferReader reads a blob being copied. It stops with the context's error once the context is
+// cancelled, so a failing worker stops the others mid-blob, honors the --bwlimit rate and reports
+// the bytes read to the progress of the blob.
+type transferReader struct {
+	ctx      context.Context
+	r        io.Reader
+	progress *blobProgress
+}
+
+// newTransferReader wraps the source of a blob copy tracked by progress
+func newTransferReader(ctx context.Context, r io.Reader, progress *blobProgress) io.Reader {
+	return &transferReader{ctx: ctx, r: r, progress: progress}
+}
+
+// openTransferAt positions a seekable blob source at offset and wraps it for the copy. The offset bytes
+// were copied by an earlier run and count as resumed in the progress of the blob.
+func openTransferAt(ctx context.Context, src io.ReadSeeker, offset int64, progress *blobProgress) (io.Reader, error) {
+	if offset > 0 {
+		if _, err := src.Seek(offset, io.SeekStart); err != nil {
+			return nil, err
+		}
+		progress.resume(offset)
+	}
+	return newTransferReader(ctx, src, progress), nil
+}
+
+// transferChunkSize bounds a single read so the bandwidth limit is applied smoothly
+const transferChunkSize = 256 * 1024
+
+func (t *transferReader) Read(p []byte) (int, error) {
+	if err := t.ctx.Err(); err != nil {
+		return 0, err
+	}
+	if transferLimiter != nil && len(p) > transferChunkSize {
+		p = p[:transferChunkSize]
+	}
+	n, err := t.r.Read(p)
+	t.progress.add(n)
+	if n > 0 && transferLimiter != nil {
+		if waitErr := transferLimiter.wait(t.ctx, n); waitErr != nil && err == nil {
+			err = waitErr
+		}
+	}
+	return n, err
+}
+
+// rateLimiterMinSleep is the shortest delay rateLimiter sleeps for
+const rateLimiterMinSleep = 20 * time.Millisecond
+
+// rateLimiter spreads transfers evenly over time so their combined rate stays below a limit
+type rateLimiter struct {
+	mu   sync.Mutex
+	rate float64   // Bytes per second
+	next time.Time // When the bandwidth used so far is paid off
+}
+
+// wait blocks until n more bytes fit into the rate, or the context is cancelled
+func (l *rateLimiter) wait(ctx context.Context, n int) error {
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	l.next = l.next.Add(time.Duration(float64(n) / l.rate * float64(time.Second)))
+	delay := l.next.Sub(now)
+	l.mu.Unlock()
+
+	// Timers are too coarse for the few milliseconds a single chunk takes at high rates. Short delays
+	// are left to build up in l.next and slept off at once, so the average rate still holds.
+	if delay < rateLimiterMinSleep {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
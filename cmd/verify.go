@@ -0,0 +1,514 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"backup_ollama/internal/utils"
+)
+
+var verifyOutputFormat string
+
+// verifyCmd represents the verify command
+var verifyCmd = &cobra.Command{
+	Use:   "verify [backup dir|archive]",
+	Short: "Verify the integrity of a backup or the live Ollama store",
+	Long: `This command checks that every manifest's config and layer blobs exist,
+that their sizes match the manifest 'size' fields and that their sha256 digests match.
+
+The argument can be a single backup directory, a backup archive (zip, tar, tar.gz
+or tar.zst, detected from its content), a directory
+containing several backups, or a backup repository. Without an argument the live Ollama store
+(~/.ollama/models) is verified.
+
+The command exits with a non-zero status if any blob fails verification.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		target := ""
+		if len(args) > 0 {
+			target = args[0]
+		}
+
+		results, err := verifyTarget(target)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error verifying: %v\n", err)
+			os.Exit(1)
+		}
+
+		if err := outputVerifyResults(results, verifyOutputFormat); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing results: %v\n", err)
+			os.Exit(1)
+		}
+
+		for _, result := range results {
+			if result.Status != verifyStatusOK {
+				os.Exit(1)
+			}
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(verifyCmd)
+	verifyCmd.Flags().StringVarP(&verifyOutputFormat, "output", "o", "text", "Output format (text, json)")
+}
+
+// Verification statuses reported for each blob
+const (
+	verifyStatusOK             = "OK"
+	verifyStatusMissing        = "MISSING"
+	verifyStatusSizeMismatch   = "SIZE MISMATCH"
+	verifyStatusDigestMismatch = "DIGEST MISMATCH"
+	verifyStatusError          = "ERROR"
+)
+
+// verifyResult is the verification outcome for a single blob referenced by a manifest
+type verifyResult struct {
+	Source   string `json:"source"`
+	Model    string `json:"model"`
+	Blob     string `json:"blob"`
+	Size     int64  `json:"size"`
+	Status   string `json:"status"`
+	Message  string `json:"message,omitempty"`
+	Expected string `json:"expected,omitempty"`
+	Actual   string `json:"actual,omitempty"`
+}
+
+// verifyManifest is a parsed manifest together with the model name it belongs to
+type verifyManifest struct {
+	Model    string
+	Path     string // Path below the manifests directory, using '/' as separator
+	Digest   string // Digest of the manifest file content
+	Manifest map[string]interface{}
+}
+
+// blobSource gives access to the blobs of a backup or of the live Ollama store
+type blobSource interface {
+	// Stat returns the size of the blob file with the given name, or an os.IsNotExist error
+	Stat(fileName string) (int64, error)
+	// Digest returns the actual sha256 digest of the blob file with the given name
+	Digest(fileName string) (string, error)
+}
+
+// verifyTarget verifies the given target, which may be empty (live store), a backup directory,
+// a backup archive or a directory containing several backups
+func verifyTarget(target string) ([]verifyResult, error) {
+	digests := make(blobDigests)
+	if target == "" {
+		return verifyLiveStore(digests)
+	}
+
+	info, err := os.Stat(target)
+	if err != nil {
+		return nil, fmt.Errorf("backup not found: %w", err)
+	}
+
+	if !info.IsDir() {
+		return verifyArchiveBackup(target)
+	}
+
+	// A single backup directory contains the library/manifests tree
+	if _, err := os.Stat(filepath.Join(target, "library", "manifests")); err == nil {
+		return verifyDirectoryBackup(target, digests)
+	}
+
+	// A repository holds its snapshots in a separate directory
+	backupsDir := target
+	if isRepository(target) {
+		backupsDir = repositorySnapshotsDir(target)
+	}
+
+	// Otherwise treat the target as a directory of backups
+	entries, err := os.ReadDir(backupsDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read backup directory: %w", err)
+	}
+
+	var results []verifyResult
+	for _, entry := range entries {
+		entryPath := filepath.Join(backupsDir, entry.Name())
+
+		var entryResults []verifyResult
+		if entry.IsDir() {
+			if _, err := os.Stat(filepath.Join(entryPath, "library", "manifests")); err != nil {
+				continue
+			}
+			entryResults, err = verifyDirectoryBackup(entryPath, digests)
+		} else if _, ok := archiveFormatOf(entry.Name()); ok {
+			entryResults, err = verifyArchiveBackup(entryPath)
+		} else {
+			continue
+		}
+
+		if err != nil {
+			results = append(results, verifyResult{Source: entryPath, Status: verifyStatusError, Message: err.Error()})
+			continue
+		}
+		results = append(results, entryResults...)
+	}
+
+	if len(results) == 0 {
+		return nil, fmt.Errorf("no backups found in %s", target)
+	}
+	return results, nil
+}
+
+// verifyLiveStore verifies every model version found by utils.EnumerateOllamaModels
+func verifyLiveStore(digests blobDigests) ([]verifyResult, error) {
+	modelList, err := utils.EnumerateOllamaModels()
+	if err != nil {
+		return nil, fmt.Errorf("failed to enumerate models: %w", err)
+	}
+
+	ollamaDir := utils.GetOllamaDirectory()
+	source := dirBlobSource{dir: filepath.Join(ollamaDir, "models", "blobs"), digests: digests}
+
+	var manifests []verifyManifest
+	for _, registry := range modelList.Registries {
+		for _, model := range registry.Models {
+			for _, version := range model.Versions {
+				ref := utils.ModelRef{Registry: registry.Name, Namespace: model.Namespace, Model: model.Name, Version: version.Name}
+				manifests = append(manifests, verifyManifest{
+					Model:    ref.String(),
+					Manifest: version.Details,
+				})
+			}
+		}
+	}
+
+	return verifyManifests(ollamaDir, manifests, source), nil
+}
+
+// verifyDirectoryBackup verifies an expanded backup directory as produced by backupModel.
+// Blobs already hashed for another backup sharing the blobs directory are taken from digests.
+func verifyDirectoryBackup(backupPath string, digests blobDigests) ([]verifyResult, error) {
+	manifestsDir := filepath.Join(backupPath, "library", "manifests")
+
+	var manifests []verifyManifest
+	err := filepath.Walk(manifestsDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+
+		relPath, err := filepath.Rel(manifestsDir, path)
+		if err != nil {
+			return err
+		}
+
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read manifest %s: %w", path, err)
+		}
+
+		manifest, err := parseVerifyManifest(filepath.ToSlash(relPath), content)
+		if err != nil {
+			return err
+		}
+		manifests = append(manifests, manifest)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	meta, err := readBackupMetadata(backupPath)
+	if err != nil {
+		return nil, err
+	}
+
+	source := dirBlobSource{dir: backupBlobsDir(backupPath), digests: digests}
+	results := verifyBackupMetadata(backupPath, meta, manifests)
+	return append(results, verifyManifests(backupPath, manifests, source)...), nil
+}
+
+// verifyArchiveBackup verifies an archived backup without extracting it.
+// The archive is read once from start to end, hashing every blob as it streams past, so compressed
+// tar archives don't have to be decompressed more than once.
+func verifyArchiveBackup(archivePath string) ([]verifyResult, error) {
+	reader, _, err := openBackupArchive(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	source := streamedBlobSource{blobs: make(map[string]streamedBlob)}
+	var manifests []verifyManifest
+	var meta *backupMetadata
+
+	for {
+		entry, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := validateArchiveEntry(entry); err != nil {
+			return nil, err
+		}
+		if entry.Mode.IsDir() {
+			continue
+		}
+
+		switch {
+		case strings.HasPrefix(entry.Name, "blobs/"):
+			size, digest, err := utils.CopyWithDigest(io.Discard, entry.Reader)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read %s from archive: %w", entry.Name, err)
+			}
+			source.blobs[path.Base(entry.Name)] = streamedBlob{Size: size, Digest: digest}
+		case strings.HasPrefix(entry.Name, "library/manifests/"):
+			content, err := readArchiveEntry(entry)
+			if err != nil {
+				return nil, err
+			}
+
+			manifest, err := parseVerifyManifest(strings.TrimPrefix(entry.Name, "library/manifests/"), content)
+			if err != nil {
+				return nil, err
+			}
+			manifests = append(manifests, manifest)
+		case entry.Name == backupMetadataFile:
+			content, err := readArchiveEntry(entry)
+			if err != nil {
+				return nil, err
+			}
+
+			meta, err = parseBackupMetadata(content)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	results := verifyBackupMetadata(archivePath, meta, manifests)
+	return append(results, verifyManifests(archivePath, manifests, source)...), nil
+}
+
+// parseVerifyManifest parses a manifest found at relPath ({registry}/{namespace}/{model}/{version}) below the manifests directory
+func parseVerifyManifest(relPath string, content []byte) (verifyManifest, error) {
+	var manifest map[string]interface{}
+	if err := json.Unmarshal(content, &manifest); err != nil {
+		return verifyManifest{}, fmt.Errorf("failed to parse manifest %s: %w", relPath, err)
+	}
+
+	// Turn "{registry}/{namespace}/{model}/{version}" into "{registry}/{namespace}/{model}:{version}"
+	model := relPath
+	if ref, ok := utils.ParseManifestPath(relPath); ok {
+		model = ref.String()
+	}
+
+	return verifyManifest{Model: model, Path: relPath, Digest: utils.BytesDigest(content), Manifest: manifest}, nil
+}
+
+// verifyBackupMetadata checks the manifest recorded in backup.json against the manifests found in the backup.
+// Backups without metadata yield no results.
+func verifyBackupMetadata(sourceName string, meta *backupMetadata, manifests []verifyManifest) []verifyResult {
+	if meta == nil {
+		return nil
+	}
+
+	result := verifyResult{Source: sourceName, Model: meta.Name, Blob: "manifest", Size: meta.ManifestSize}
+	for _, m := range manifests {
+		if "library/manifests/"+m.Path != meta.ManifestPath {
+			continue
+		}
+		if m.Digest != meta.ManifestDigest {
+			result.Status = verifyStatusDigestMismatch
+			result.Expected = meta.ManifestDigest
+			result.Actual = m.Digest
+		} else {
+			result.Status = verifyStatusOK
+		}
+		return []verifyResult{result}
+	}
+
+	result.Status = verifyStatusMissing
+	result.Message = fmt.Sprintf("%s recorded in %s", meta.ManifestPath, backupMetadataFile)
+	return []verifyResult{result}
+}
+
+// verifyManifests checks every config and layer blob referenced by the given manifests
+func verifyManifests(sourceName string, manifests []verifyManifest, source blobSource) []verifyResult {
+	sort.SliceStable(manifests, func(i, j int) bool {
+		return manifests[i].Model < manifests[j].Model
+	})
+
+	var results []verifyResult
+	for _, m := range manifests {
+		for _, blob := range utils.ManifestBlobs(m.Manifest) {
+			result := verifyBlob(source, blob)
+			result.Source = sourceName
+			result.Model = m.Model
+			results = append(results, result)
+		}
+	}
+	return results
+}
+
+// verifyBlob checks the existence, size and digest of a single blob
+func verifyBlob(source blobSource, blob utils.BlobRef) verifyResult {
+	fileName := blob.FileName()
+	result := verifyResult{Blob: fileName, Size: blob.Size}
+
+	expected, err := utils.ExpectedDigest(fileName, blob.Digest)
+	if err != nil {
+		result.Status = verifyStatusDigestMismatch
+		result.Message = err.Error()
+		return result
+	}
+
+	size, err := source.Stat(fileName)
+	if os.IsNotExist(err) {
+		result.Status = verifyStatusMissing
+		return result
+	} else if err != nil {
+		result.Status = verifyStatusError
+		result.Message = err.Error()
+		return result
+	}
+
+	if blob.Size > 0 && size != blob.Size {
+		result.Status = verifyStatusSizeMismatch
+		result.Expected = fmt.Sprintf("%d", blob.Size)
+		result.Actual = fmt.Sprintf("%d", size)
+		return result
+	}
+
+	actual, err := source.Digest(fileName)
+	if err != nil {
+		result.Status = verifyStatusError
+		result.Message = err.Error()
+		return result
+	}
+
+	if actual != expected {
+		result.Status = verifyStatusDigestMismatch
+		result.Expected = expected
+		result.Actual = actual
+		return result
+	}
+
+	result.Status = verifyStatusOK
+	return result
+}
+
+// outputVerifyResults prints the verification results as a pass/fail table or as JSON
+func outputVerifyResults(results []verifyResult, format string) error {
+	if strings.ToLower(format) == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(results); err != nil {
+			return fmt.Errorf("failed to encode JSON: %w", err)
+		}
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+
+	var failed int
+	currentSource := ""
+	for _, result := range results {
+		if result.Source != currentSource {
+			currentSource = result.Source
+			fmt.Fprintf(w, "Source: %s\n", currentSource)
+			fmt.Fprintf(w, "  %s\t%s\t%s\t%s\n", "MODEL", "BLOB", "SIZE", "STATUS")
+			fmt.Fprintf(w, "  %s\t%s\t%s\t%s\n", "-----", "----", "----", "------")
+		}
+
+		if result.Status != verifyStatusOK {
+			failed++
+		}
+
+		status := result.Status
+		if result.Message != "" {
+			status = fmt.Sprintf("%s (%s)", status, result.Message)
+		} else if result.Expected != "" {
+			status = fmt.Sprintf("%s (expected %s, got %s)", status, result.Expected, result.Actual)
+		}
+
+		fmt.Fprintf(w, "  %s\t%s\t%s\t%s\n",
+			result.Model,
+			truncateString(result.Blob, 24),
+			formatBytes(result.Size),
+			status)
+	}
+
+	fmt.Fprintf(w, "\n%d blobs checked, %d passed, %d failed\n", len(results), len(results)-failed, failed)
+	return w.Flush()
+}
+
+// dirBlobSource reads blobs from a blobs directory on disk
+type dirBlobSource struct {
+	dir     string
+	digests blobDigests // Digests hashed so far in this verify run, may be nil
+}
+
+// blobDigests caches the digests of the blob files hashed during one verify run, keyed by path, so a blob
+// shared by several manifests or repository snapshots is only read once
+type blobDigests map[string]blobDigest
+
+// blobDigest is the result of hashing a blob file
+type blobDigest struct {
+	digest string
+	err    error
+}
+
+func (s dirBlobSource) Stat(fileName string) (int64, error) {
+	info, err := os.Stat(filepath.Join(s.dir, fileName))
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+func (s dirBlobSource) Digest(fileName string) (string, error) {
+	blobPath := filepath.Join(s.dir, fileName)
+	if cached, ok := s.digests[blobPath]; ok {
+		return cached.digest, cached.err
+	}
+	digest, _, err := utils.FileDigest(blobPath)
+	if s.digests != nil {
+		s.digests[blobPath] = blobDigest{digest: digest, err: err}
+	}
+	return digest, err
+}
+
+// streamedBlob is the size and digest of a blob hashed while reading an archive
+type streamedBlob struct {
+	Size   int64
+	Digest string
+}
+
+// streamedBlobSource holds the blobs of an archive that were hashed in a single pass
+type streamedBlobSource struct {
+	blobs map[string]streamedBlob
+}
+
+func (s streamedBlobSource) Stat(fileName string) (int64, error) {
+	blob, ok := s.blobs[fileName]
+	if !ok {
+		return 0, os.ErrNotExist
+	}
+	return blob.Size, nil
+}
+
+func (s streamedBlobSource) Digest(fileName string) (string, error) {
+	blob, ok := s.blobs[fileName]
+	if !ok {
+		return "", os.ErrNotExist
+	}
+	return blob.Digest, nil
+}
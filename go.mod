@@ -1,8 +1,11 @@
 module backup_ollama
 
-go 1.18
+go 1.21
 
-require github.com/spf13/cobra v1.5.0
+require (
+	github.com/klauspost/compress v1.17.11
+	github.com/spf13/cobra v1.5.0
+)
 
 require (
 	github.com/inconshreveable/mousetrap v1.0.0 // indirect
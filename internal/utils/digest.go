@@ -0,0 +1,160 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// BlobRef describes a single blob referenced by a manifest, either as its config or as one of its layers
+type BlobRef struct {
+	Digest    string // Digest in the manifest format, e.g. "sha256:123abc..."
+	MediaType string // Media type of the blob as recorded in the manifest
+	Size      int64  // Size of the blob as recorded in the manifest
+	From      string // Optional 'from' path relative to the Ollama directory
+}
+
+// FileName returns the name of the blob file in a blobs directory.
+// If the blob has a 'from' field, its base name is used, otherwise the digest is converted
+// from "sha256:123abc..." to "sha256-123abc...".
+func (b BlobRef) FileName() string {
+	if b.From != "" {
+		return filepath.Base(b.From)
+	}
+	return DigestToFileName(b.Digest)
+}
+
+// DigestMismatchError is returned when the content of a blob does not match its expected digest
+type DigestMismatchError struct {
+	Blob     string
+	Expected string
+	Actual   string
+}
+
+func (e *DigestMismatchError) Error() string {
+	return fmt.Sprintf("digest mismatch for blob %s: expected %s, got %s", e.Blob, e.Expected, e.Actual)
+}
+
+// SizeMismatchError is returned when the size of a blob does not match its expected size
+type SizeMismatchError struct {
+	Blob     string
+	Expected int64
+	Actual   int64
+}
+
+func (e *SizeMismatchError) Error() string {
+	return fmt.Sprintf("size mismatch for blob %s: expected %d bytes, got %d bytes", e.Blob, e.Expected, e.Actual)
+}
+
+// ManifestBlobs returns the config blob (if any) followed by all layers referenced by a parsed manifest
+func ManifestBlobs(manifest map[string]interface{}) []BlobRef {
+	var blobs []BlobRef
+
+	if config, ok := manifest["config"].(map[string]interface{}); ok {
+		if blob, ok := parseBlobRef(config); ok {
+			blobs = append(blobs, blob)
+		}
+	}
+
+	if layers, ok := manifest["layers"].([]interface{}); ok {
+		for _, layer := range layers {
+			if layerMap, ok := layer.(map[string]interface{}); ok {
+				if blob, ok := parseBlobRef(layerMap); ok {
+					blobs = append(blobs, blob)
+				}
+			}
+		}
+	}
+
+	return blobs
+}
+
+// parseBlobRef converts a manifest config or layer entry into a BlobRef.
+// Entries with neither a 'from' nor a 'digest' field are skipped.
+func parseBlobRef(entry map[string]interface{}) (BlobRef, bool) {
+	var blob BlobRef
+	blob.Digest, _ = entry["digest"].(string)
+	blob.MediaType, _ = entry["mediaType"].(string)
+	blob.From, _ = entry["from"].(string)
+	if size, ok := entry["size"].(float64); ok {
+		blob.Size = int64(size)
+	}
+	if blob.Digest == "" && blob.From == "" {
+		return BlobRef{}, false
+	}
+	return blob, true
+}
+
+// DigestToFileName converts a digest from "sha256:123abc..." to the blob file name "sha256-123abc..."
+func DigestToFileName(digest string) string {
+	return strings.Replace(digest, ":", "-", 1)
+}
+
+// FileNameToDigest converts a blob file name from "sha256-123abc..." to the digest "sha256:123abc...".
+// It returns an empty string if the name is not a valid sha256 blob file name.
+func FileNameToDigest(name string) string {
+	if !strings.HasPrefix(name, "sha256-") {
+		return ""
+	}
+	hexPart := strings.TrimPrefix(name, "sha256-")
+	if len(hexPart) != sha256.Size*2 {
+		return ""
+	}
+	if _, err := hex.DecodeString(hexPart); err != nil {
+		return ""
+	}
+	return "sha256:" + hexPart
+}
+
+// ExpectedDigest returns the digest a blob file must hash to, taking both the manifest digest and
+// the "sha256-<hex>" file name into account. An error is returned if the two disagree.
+func ExpectedDigest(fileName, manifestDigest string) (string, error) {
+	nameDigest := FileNameToDigest(fileName)
+	switch {
+	case manifestDigest == "" && nameDigest == "":
+		return "", fmt.Errorf("blob %s has no sha256 digest to verify against", fileName)
+	case manifestDigest == "":
+		return nameDigest, nil
+	case !strings.HasPrefix(manifestDigest, "sha256:"):
+		return "", fmt.Errorf("blob %s has unsupported digest algorithm: %s", fileName, manifestDigest)
+	case nameDigest != "" && nameDigest != manifestDigest:
+		return "", &DigestMismatchError{Blob: fileName, Expected: manifestDigest, Actual: nameDigest}
+	}
+	return manifestDigest, nil
+}
+
+// CopyWithDigest copies src to dst while hashing the streamed bytes.
+// It returns the number of bytes copied and the resulting digest in the "sha256:123abc..." format.
+func CopyWithDigest(dst io.Writer, src io.Reader) (int64, string, error) {
+	hasher := sha256.New()
+	written, err := io.Copy(io.MultiWriter(dst, hasher), src)
+	if err != nil {
+		return written, "", err
+	}
+	return written, "sha256:" + hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// BytesDigest returns the sha256 digest of data in the "sha256:123abc..." format
+func BytesDigest(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// FileDigest computes the sha256 digest and size of the file at path
+func FileDigest(path string) (string, int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer file.Close()
+
+	size, digest, err := CopyWithDigest(io.Discard, file)
+	if err != nil {
+		return "", 0, err
+	}
+	return digest, size, nil
+}
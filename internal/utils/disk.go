@@ -0,0 +1,29 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// DiskFree returns the number of bytes available to the current user on the file system holding path.
+// A path that doesn't exist yet, such as a backup directory about to be created, is looked up through its
+// nearest existing parent directory.
+func DiskFree(path string) (uint64, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return 0, err
+	}
+	for {
+		if _, err := os.Stat(path); err == nil {
+			break
+		} else if !os.IsNotExist(err) {
+			return 0, err
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			break
+		}
+		path = parent
+	}
+	return diskFree(path)
+}
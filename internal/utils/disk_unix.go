@@ -0,0 +1,15 @@
+//go:build unix
+
+package utils
+
+import "syscall"
+
+// diskFree queries the file system holding the existing path with statfs
+func diskFree(path string) (uint64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, err
+	}
+	// Bavail counts the blocks available to unprivileged users, unlike Bfree
+	return uint64(stat.Bavail) * uint64(stat.Bsize), nil
+}
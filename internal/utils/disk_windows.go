@@ -0,0 +1,31 @@
+//go:build windows
+
+package utils
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+var procGetDiskFreeSpaceExW = syscall.NewLazyDLL("kernel32.dll").NewProc("GetDiskFreeSpaceExW")
+
+// diskFree queries the volume holding the existing path with GetDiskFreeSpaceExW
+func diskFree(path string) (uint64, error) {
+	pathPtr, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return 0, err
+	}
+
+	// The first result is the space available to the current user, which honors disk quotas
+	var available, total, free uint64
+	ret, _, err := procGetDiskFreeSpaceExW.Call(
+		uintptr(unsafe.Pointer(pathPtr)),
+		uintptr(unsafe.Pointer(&available)),
+		uintptr(unsafe.Pointer(&total)),
+		uintptr(unsafe.Pointer(&free)),
+	)
+	if ret == 0 {
+		return 0, err
+	}
+	return available, nil
+}
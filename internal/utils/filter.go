@@ -0,0 +1,97 @@
+package utils
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// Refs returns a reference to every model version in the list, in enumeration order
+func (l *OllamaModelList) Refs() []ModelRef {
+	var refs []ModelRef
+	for _, registry := range l.Registries {
+		for _, model := range registry.Models {
+			for _, version := range model.Versions {
+				refs = append(refs, ModelRef{
+					Registry:  registry.Name,
+					Namespace: model.Namespace,
+					Model:     model.Name,
+					Version:   version.Name,
+				})
+			}
+		}
+	}
+	return refs
+}
+
+// IsGlobPattern reports whether name contains any glob meta characters
+func IsGlobPattern(name string) bool {
+	return strings.ContainsAny(name, "*?[")
+}
+
+// MatchGlob reports whether ref matches a glob pattern in the format '[[{registry}/]{namespace}/]{model}[:{version}]'.
+// Each part is matched separately using path.Match semantics; a missing registry, namespace or version matches any value.
+func MatchGlob(pattern string, ref ModelRef) (bool, error) {
+	parsed, err := ParseModelName(pattern)
+	if err != nil {
+		return false, err
+	}
+
+	for _, part := range []struct{ pattern, value string }{
+		{parsed.Registry, ref.Registry},
+		{parsed.Namespace, ref.Namespace},
+		{parsed.Model, ref.Model},
+		{parsed.Version, ref.Version},
+	} {
+		if part.pattern == "" {
+			continue
+		}
+		matched, err := path.Match(part.pattern, part.value)
+		if err != nil {
+			return false, fmt.Errorf("invalid pattern '%s': %w", part.pattern, err)
+		}
+		if !matched {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// FilterModels returns the model versions in the list that match any of the glob patterns or regular expressions.
+// Regular expressions are matched against the fully qualified name '{registry}/{namespace}/{model}:{version}'.
+func FilterModels(list *OllamaModelList, globs []string, regexps []string) ([]ModelRef, error) {
+	compiled := make([]*regexp.Regexp, 0, len(regexps))
+	for _, expr := range regexps {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regular expression '%s': %w", expr, err)
+		}
+		compiled = append(compiled, re)
+	}
+
+	var matches []ModelRef
+	for _, ref := range list.Refs() {
+		matched := false
+		for _, glob := range globs {
+			ok, err := MatchGlob(glob, ref)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				matched = true
+				break
+			}
+		}
+		for _, re := range compiled {
+			if matched {
+				break
+			}
+			matched = re.MatchString(ref.String())
+		}
+		if matched {
+			matches = append(matches, ref)
+		}
+	}
+	return matches, nil
+}
@@ -0,0 +1,28 @@
+package utils
+
+import "os"
+
+// FileLock is an exclusive lock held on a lock file
+type FileLock struct {
+	file *os.File
+}
+
+// LockFile creates the file at path if needed and waits until it holds an exclusive lock on it.
+// The lock is released by Unlock, or by the operating system when the process exits, so a crashed
+// process never leaves it held.
+func LockFile(path string) (*FileLock, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := lockFile(file); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &FileLock{file: file}, nil
+}
+
+// Unlock releases the lock. Closing the file releases it on every platform.
+func (l *FileLock) Unlock() error {
+	return l.file.Close()
+}
@@ -0,0 +1,10 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd && !dragonfly && !windows
+
+package utils
+
+import "os"
+
+// lockFile does nothing on platforms without flock or LockFileEx; concurrent runs are not protected there
+func lockFile(file *os.File) error {
+	return nil
+}
@@ -0,0 +1,18 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+
+package utils
+
+import (
+	"os"
+	"syscall"
+)
+
+// lockFile takes an exclusive flock on file, waiting for other holders to release it
+func lockFile(file *os.File) error {
+	for {
+		err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX)
+		if err != syscall.EINTR {
+			return err
+		}
+	}
+}
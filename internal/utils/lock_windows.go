@@ -0,0 +1,31 @@
+//go:build windows
+
+package utils
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+var procLockFileEx = syscall.NewLazyDLL("kernel32.dll").NewProc("LockFileEx")
+
+// lockfileExclusiveLock is the LOCKFILE_EXCLUSIVE_LOCK flag of LockFileEx
+const lockfileExclusiveLock = 0x2
+
+// lockFile takes an exclusive lock on the first byte of file with LockFileEx, waiting for other holders to release it
+func lockFile(file *os.File) error {
+	var overlapped syscall.Overlapped
+	ret, _, err := procLockFileEx.Call(
+		file.Fd(),
+		lockfileExclusiveLock,
+		0,
+		1,
+		0,
+		uintptr(unsafe.Pointer(&overlapped)),
+	)
+	if ret == 0 {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// DefaultRegistry is the registry host used when a model name doesn't specify one
+	DefaultRegistry = "registry.ollama.ai"
+	// DefaultNamespace is the namespace of official models, used when a model name doesn't specify one
+	DefaultNamespace = "library"
+)
+
+// ModelRef identifies a single version of a model in an OllamaModelList
+type ModelRef struct {
+	Registry  string
+	Namespace string
+	Model     string
+	Version   string
+}
+
+// String returns the fully qualified name of the model version in the format '{registry}/{namespace}/{model}:{version}'
+func (r ModelRef) String() string {
+	return fmt.Sprintf("%s/%s/%s:%s", r.Registry, r.Namespace, r.Model, r.Version)
+}
+
+// ShortName returns the shortest name that Ollama resolves to this model version:
+// '{model}:{version}' for official models, '{namespace}/{model}:{version}' for other namespaces
+// of the default registry, and the fully qualified name for any other registry
+func (r ModelRef) ShortName() string {
+	switch {
+	case r.Registry != DefaultRegistry:
+		return r.String()
+	case r.Namespace != DefaultNamespace:
+		return fmt.Sprintf("%s/%s:%s", r.Namespace, r.Model, r.Version)
+	default:
+		return fmt.Sprintf("%s:%s", r.Model, r.Version)
+	}
+}
+
+// ManifestPath returns the path of the manifest relative to the manifests directory, using '/' as separator
+func (r ModelRef) ManifestPath() string {
+	return strings.Join([]string{r.Registry, r.Namespace, r.Model, r.Version}, "/")
+}
+
+// ParseModelName parses a model name in the format '[[{registry}/]{namespace}/]{model}[:{version}]'.
+// Parts that are not given are left empty so callers can decide how to default or match them.
+func ParseModelName(name string) (ModelRef, error) {
+	var ref ModelRef
+
+	// The version follows the last ':' after the last '/', so registry hosts with ports are not mistaken for versions
+	slash := strings.LastIndex(name, "/")
+	if colon := strings.LastIndex(name, ":"); colon > slash {
+		ref.Version = name[colon+1:]
+		name = name[:colon]
+	}
+
+	parts := strings.Split(name, "/")
+	switch len(parts) {
+	case 1:
+		ref.Model = parts[0]
+	case 2:
+		ref.Namespace, ref.Model = parts[0], parts[1]
+	case 3:
+		ref.Registry, ref.Namespace, ref.Model = parts[0], parts[1], parts[2]
+	default:
+		return ModelRef{}, fmt.Errorf("invalid model name '%s': expected [[registry/]namespace/]model[:version]", name)
+	}
+
+	for _, part := range parts {
+		if part == "" || part == "." || part == ".." {
+			return ModelRef{}, fmt.Errorf("invalid model name '%s'", name)
+		}
+	}
+	return ref, nil
+}
+
+// ParseManifestPath parses a manifest path relative to the manifests directory
+// ('{registry}/{namespace}/{model}/{version}', using '/' as separator) into a ModelRef.
+// Paths with empty, '.' or '..' parts or with backslashes are rejected, so the result can't point outside
+// the manifests directory.
+func ParseManifestPath(relPath string) (ModelRef, bool) {
+	parts := strings.Split(relPath, "/")
+	if len(parts) != 4 {
+		return ModelRef{}, false
+	}
+	for _, part := range parts {
+		if part == "" || part == "." || part == ".." || strings.ContainsAny(part, "\\\x00") {
+			return ModelRef{}, false
+		}
+	}
+	return ModelRef{Registry: parts[0], Namespace: parts[1], Model: parts[2], Version: parts[3]}, true
+}
@@ -10,22 +10,24 @@ import (
 
 // ModelVersion represents information about a specific model version
 type ModelVersion struct {
-	Name       string
-	Path       string
-	Digest     string
-	Size       int64                  // Size of the manifest file only
-	TotalSize  int64                  // Total size including all blob files
-	BlobsSize  int64                  // Size of blob files only
-	BlobsCount int                    // Number of blob files
-	Details    map[string]interface{} // Parsed content of the version JSON file
+	Name           string
+	Path           string
+	Digest         string
+	Size           int64                  // Size of the manifest file only
+	TotalSize      int64                  // Total size including all blob files
+	BlobsSize      int64                  // Size of blob files only
+	BlobsCount     int                    // Number of blob files
+	Details        map[string]interface{} // Parsed content of the version JSON file
+	ManifestDigest string                 // sha256 digest of the raw manifest file, identifying the exact manifest bytes
 }
 
 // Model represents a model with its versions
 type Model struct {
-	Name     string
-	Registry string
-	Path     string
-	Versions []ModelVersion
+	Name      string
+	Registry  string
+	Namespace string
+	Path      string
+	Versions  []ModelVersion
 }
 
 // Registry represents a registry with its models
@@ -76,7 +78,8 @@ func sanitizeModelName(modelName string) string {
 // EnumerateOllamaModels scans the Ollama directory structure and returns information
 // about all registries, models, and versions found.
 // The structure is expected to be:
-// ~/.ollama/models/manifests/{registry}/library/{model}/{version}
+// ~/.ollama/models/manifests/{registry}/{namespace}/{model}/{version}
+// where the namespace is "library" for official models and the user or organization name otherwise.
 func EnumerateOllamaModels() (*OllamaModelList, error) {
 	manifestsDir := GetManifestsDirectory()
 	if _, err := os.Stat(manifestsDir); os.IsNotExist(err) {
@@ -111,126 +114,137 @@ func EnumerateOllamaModels() (*OllamaModelList, error) {
 			Models: []Model{},
 		}
 
-		// Step 2: Look for the "library" directory
-		libraryPath := filepath.Join(registryPath, "library")
-		if _, err := os.Stat(libraryPath); os.IsNotExist(err) {
-			continue
-		}
-
-		// Step 3: List model directories
-		modelEntries, err := os.ReadDir(libraryPath)
+		// Step 2: List namespace directories ("library" for official models)
+		namespaceEntries, err := os.ReadDir(registryPath)
 		if err != nil {
-			return nil, fmt.Errorf("failed to read library directory: %w", err)
+			return nil, fmt.Errorf("failed to read registry directory: %w", err)
 		}
 
-		for _, modelEntry := range modelEntries {
-			if !modelEntry.IsDir() {
+		for _, namespaceEntry := range namespaceEntries {
+			if !namespaceEntry.IsDir() {
 				continue
 			}
 
-			modelName := modelEntry.Name()
-			modelPath := filepath.Join(libraryPath, modelName)
+			namespaceName := namespaceEntry.Name()
+			namespacePath := filepath.Join(registryPath, namespaceName)
 
-			model := Model{
-				Name:     modelName,
-				Registry: registryName,
-				Path:     modelPath,
-				Versions: []ModelVersion{},
-			}
-
-			// Step 4: List version files
-			versionEntries, err := os.ReadDir(modelPath)
+			// Step 3: List model directories
+			modelEntries, err := os.ReadDir(namespacePath)
 			if err != nil {
-				return nil, fmt.Errorf("failed to read model directory: %w", err)
+				return nil, fmt.Errorf("failed to read namespace directory: %w", err)
 			}
 
-			for _, versionEntry := range versionEntries {
-				if versionEntry.IsDir() {
-					continue // Skip directories, we're looking for JSON files
+			for _, modelEntry := range modelEntries {
+				if !modelEntry.IsDir() {
+					continue
 				}
 
-				versionPath := filepath.Join(modelPath, versionEntry.Name())
+				modelName := modelEntry.Name()
+				modelPath := filepath.Join(namespacePath, modelName)
 
-				// Parse the version JSON file
-				versionInfo, err := parseVersionFile(versionPath)
-				if err != nil {
-					// Skip this version if we can't parse it
-					continue
+				model := Model{
+					Name:      modelName,
+					Registry:  registryName,
+					Namespace: namespaceName,
+					Path:      modelPath,
+					Versions:  []ModelVersion{},
 				}
 
-				fileInfo, err := os.Stat(versionPath)
+				// Step 4: List version files
+				versionEntries, err := os.ReadDir(modelPath)
 				if err != nil {
-					continue
+					return nil, fmt.Errorf("failed to read model directory: %w", err)
 				}
 
-				version := ModelVersion{
-					Name:       versionEntry.Name(),
-					Path:       versionPath,
-					Size:       fileInfo.Size(), // Manifest file size
-					TotalSize:  fileInfo.Size(), // Initialize with manifest size, will add blob sizes
-					BlobsSize:  0,               // Initialize blob size to 0
-					BlobsCount: 0,               // Initialize blob count to 0
-					Details:    versionInfo,
-				}
+				for _, versionEntry := range versionEntries {
+					if versionEntry.IsDir() {
+						continue // Skip directories, we're looking for JSON files
+					}
 
-				// Calculate blob sizes and update total size
-				if layers, ok := versionInfo["layers"].([]interface{}); ok {
-					for _, layer := range layers {
-						if layerMap, ok := layer.(map[string]interface{}); ok {
-							var blobPath string
-
-							// Determine blob path from either 'from' or 'digest' field
-							if from, ok := layerMap["from"].(string); ok {
-								blobPath = filepath.Join(ollamaDir, from)
-							} else if digest, ok := layerMap["digest"].(string); ok {
-								// Convert digest format from "sha256:123abc..." to "sha256-123abc..."
-								digestName := strings.Replace(digest, ":", "-", 1)
-								blobPath = filepath.Join(blobsDir, digestName)
-							} else {
-								// Skip this layer if neither from nor digest is present
-								continue
-							}
+					versionPath := filepath.Join(modelPath, versionEntry.Name())
+
+					// Parse the version JSON file
+					versionInfo, manifestDigest, err := parseVersionFile(versionPath)
+					if err != nil {
+						// Skip this version if we can't parse it
+						continue
+					}
 
-							// Check if blob exists and get its size
-							if blobInfo, err := os.Stat(blobPath); err == nil {
-								blobSize := blobInfo.Size()
-								version.BlobsSize += blobSize // Add to blob-specific size
-								version.TotalSize += blobSize // Add to total size
-								version.BlobsCount++          // Increment blob count
+					fileInfo, err := os.Stat(versionPath)
+					if err != nil {
+						continue
+					}
+
+					version := ModelVersion{
+						Name:           versionEntry.Name(),
+						Path:           versionPath,
+						Size:           fileInfo.Size(), // Manifest file size
+						TotalSize:      fileInfo.Size(), // Initialize with manifest size, will add blob sizes
+						BlobsSize:      0,               // Initialize blob size to 0
+						BlobsCount:     0,               // Initialize blob count to 0
+						Details:        versionInfo,
+						ManifestDigest: manifestDigest,
+					}
+
+					// Calculate blob sizes and update total size
+					if layers, ok := versionInfo["layers"].([]interface{}); ok {
+						for _, layer := range layers {
+							if layerMap, ok := layer.(map[string]interface{}); ok {
+								var blobPath string
+
+								// Determine blob path from either 'from' or 'digest' field
+								if from, ok := layerMap["from"].(string); ok {
+									blobPath = filepath.Join(ollamaDir, from)
+								} else if digest, ok := layerMap["digest"].(string); ok {
+									// Convert digest format from "sha256:123abc..." to "sha256-123abc..."
+									digestName := strings.Replace(digest, ":", "-", 1)
+									blobPath = filepath.Join(blobsDir, digestName)
+								} else {
+									// Skip this layer if neither from nor digest is present
+									continue
+								}
+
+								// Check if blob exists and get its size
+								if blobInfo, err := os.Stat(blobPath); err == nil {
+									blobSize := blobInfo.Size()
+									version.BlobsSize += blobSize // Add to blob-specific size
+									version.TotalSize += blobSize // Add to total size
+									version.BlobsCount++          // Increment blob count
+								}
 							}
 						}
 					}
-				}
 
-				// Extract digest if available
-				if digest, ok := versionInfo["digest"].(string); ok {
-					version.Digest = digest
-				} else {
-					// Try to extract digest from the manifest structure
-					// Check for config digest
-					if config, ok := versionInfo["config"].(map[string]interface{}); ok {
-						if configDigest, ok := config["digest"].(string); ok {
-							version.Digest = configDigest
+					// Extract digest if available
+					if digest, ok := versionInfo["digest"].(string); ok {
+						version.Digest = digest
+					} else {
+						// Try to extract digest from the manifest structure
+						// Check for config digest
+						if config, ok := versionInfo["config"].(map[string]interface{}); ok {
+							if configDigest, ok := config["digest"].(string); ok {
+								version.Digest = configDigest
+							}
 						}
-					}
 
-					// If config digest is not found, try to get the first layer digest
-					if version.Digest == "" {
-						if layers, ok := versionInfo["layers"].([]interface{}); ok && len(layers) > 0 {
-							if layer, ok := layers[0].(map[string]interface{}); ok {
-								if layerDigest, ok := layer["digest"].(string); ok {
-									version.Digest = layerDigest
+						// If config digest is not found, try to get the first layer digest
+						if version.Digest == "" {
+							if layers, ok := versionInfo["layers"].([]interface{}); ok && len(layers) > 0 {
+								if layer, ok := layers[0].(map[string]interface{}); ok {
+									if layerDigest, ok := layer["digest"].(string); ok {
+										version.Digest = layerDigest
+									}
 								}
 							}
 						}
 					}
-				}
 
-				model.Versions = append(model.Versions, version)
-			}
+					model.Versions = append(model.Versions, version)
+				}
 
-			if len(model.Versions) > 0 {
-				registry.Models = append(registry.Models, model)
+				if len(model.Versions) > 0 {
+					registry.Models = append(registry.Models, model)
+				}
 			}
 		}
 
@@ -242,17 +256,18 @@ func EnumerateOllamaModels() (*OllamaModelList, error) {
 	return result, nil
 }
 
-// parseVersionFile reads and parses a version JSON file
-func parseVersionFile(path string) (map[string]interface{}, error) {
+// parseVersionFile reads and parses a version JSON file.
+// It also returns the sha256 digest of the raw file content.
+func parseVersionFile(path string) (map[string]interface{}, string, error) {
 	content, err := os.ReadFile(path)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read version file: %w", err)
+		return nil, "", fmt.Errorf("failed to read version file: %w", err)
 	}
 
 	var versionInfo map[string]interface{}
 	if err := json.Unmarshal(content, &versionInfo); err != nil {
-		return nil, fmt.Errorf("failed to parse version file: %w", err)
+		return nil, "", fmt.Errorf("failed to parse version file: %w", err)
 	}
 
-	return versionInfo, nil
+	return versionInfo, BytesDigest(content), nil
 }